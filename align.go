@@ -0,0 +1,20 @@
+package gomem
+
+import "unsafe"
+
+// alignUp rounds n up to the next multiple of align
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// makeAligned allocates size bytes from the Go heap starting at an address
+// that is a multiple of align
+func makeAligned(size, align int) []byte {
+	if align <= 1 {
+		return make([]byte, size)
+	}
+	buf := make([]byte, size+align-1)
+	addr := int(uintptr(unsafe.Pointer(&buf[0])))
+	pad := alignUp(addr, align) - addr
+	return buf[pad : pad+size : pad+size]
+}
@@ -1,35 +1,46 @@
-//go:build !twotree
-
 package gomem
 
 type (
-	Block struct {
-		Start, End          int
-		parent, left, right *Block
-	}
-	History struct {
-		Malloc bool
-		Offset int
-		Size   int
+	treapBlock struct {
+		Block
+		parent, left, right *treapBlock
+		maxSize             int // largest block size in this subtree
 	}
+	// Allocator is the treap implementation of FreeSpaceIndex (StrategyTreap).
 	Allocator struct {
-		pool     *Block
-		sizeTree *Block // Single treap instead of sizeTree/offsetTree
+		pool     *treapBlock
+		sizeTree *treapBlock // Single treap instead of sizeTree/offsetTree
 		Size     int
-		// history  []History
+		Policy   FitPolicy
+		cursor   int // where FitNext resumes searching
 	}
 )
 
-// Update NewAllocator
-func NewAllocator(size int) (result *Allocator) {
+// NewAllocator creates a treap allocator of size bytes, optionally with a
+// fit policy other than FitDefault.
+func NewAllocator(size int, policy ...FitPolicy) (result *Allocator) {
 	result = &Allocator{
-		sizeTree: &Block{Start: 0, End: size},
+		sizeTree: &treapBlock{Block: Block{Start: 0, End: size}, maxSize: size},
 		Size:     size,
 	}
+	if len(policy) > 0 {
+		result.Policy = policy[0]
+	}
 	return
 }
 
-func (p *Block) rotateDone(x, y *Block, a *Allocator) {
+func (b *treapBlock) getMaxSize() int {
+	if b == nil {
+		return 0
+	}
+	return b.maxSize
+}
+
+func (b *treapBlock) update() {
+	b.maxSize = max(b.End-b.Start, b.left.getMaxSize(), b.right.getMaxSize())
+}
+
+func (p *treapBlock) rotateDone(x, y *treapBlock, a *Allocator) {
 	x.parent = p
 	if p == nil {
 		a.sizeTree = x
@@ -41,25 +52,29 @@ func (p *Block) rotateDone(x, y *Block, a *Allocator) {
 }
 
 // Add rotation helpers similar to semaRoot
-func (x *Block) rotateLeft(a *Allocator) {
+func (x *treapBlock) rotateLeft(a *Allocator) {
 	p, y, b := x.parent, x.right, x.right.left
 	y.left, x.parent, x.right = x, y, b
 	if b != nil {
 		b.parent = x
 	}
+	x.update()
+	y.update()
 	p.rotateDone(y, x, a)
 }
 
-func (y *Block) rotateRight(a *Allocator) {
+func (y *treapBlock) rotateRight(a *Allocator) {
 	p, x, b := y.parent, y.left, y.left.right
 	x.right, y.parent, y.left = y, x, b
 	if b != nil {
 		b.parent = y
 	}
+	y.update()
+	x.update()
 	p.rotateDone(x, y, a)
 }
 
-func (b *Block) insert(block *Block, allocator *Allocator) *Block {
+func (b *treapBlock) insert(block *treapBlock, allocator *Allocator) *treapBlock {
 	if b == nil {
 		return block
 	}
@@ -81,6 +96,8 @@ func (b *Block) insert(block *Block, allocator *Allocator) *Block {
 		}
 	}
 
+	b.update()
+
 	// Heapify based on block size (End-Start)
 	blockSize := block.End - block.Start
 	nodeSize := b.End - b.Start
@@ -98,7 +115,7 @@ func (b *Block) insert(block *Block, allocator *Allocator) *Block {
 	return b
 }
 
-func (b *Block) find(size int) (block *Block) {
+func (b *treapBlock) find(size int) (block *treapBlock) {
 	if b == nil {
 		return nil
 	}
@@ -120,7 +137,67 @@ func (b *Block) find(size int) (block *Block) {
 	return b.right.find(size)
 }
 
-func (b *Block) Walk(fn func(*Block)) {
+// findBest returns the smallest block of at least size bytes. Sizes grow
+// downwards in the treap, so a block that fits beats its whole subtree.
+func (b *treapBlock) findBest(size int) *treapBlock {
+	if b == nil || b.maxSize < size {
+		return nil
+	}
+	if b.End-b.Start >= size {
+		return b
+	}
+	left, right := b.left.findBest(size), b.right.findBest(size)
+	if left == nil || right != nil && right.End-right.Start < left.End-left.Start {
+		return right
+	}
+	return left
+}
+
+// findFirst returns the lowest-offset block of at least size bytes that
+// starts at or after from
+func (b *treapBlock) findFirst(size, from int) *treapBlock {
+	if b == nil || b.maxSize < size {
+		return nil
+	}
+	if b.Start >= from {
+		if block := b.left.findFirst(size, from); block != nil {
+			return block
+		}
+		if b.End-b.Start >= size {
+			return b
+		}
+	}
+	return b.right.findFirst(size, from)
+}
+
+// findLargest returns the largest block
+func (b *treapBlock) findLargest() *treapBlock {
+	for b != nil && b.End-b.Start != b.maxSize {
+		if b.left.getMaxSize() == b.maxSize {
+			b = b.left
+		} else {
+			b = b.right
+		}
+	}
+	return b
+}
+
+// findAligned returns the lowest-offset block that can hold size bytes at a
+// multiple of align
+func (b *treapBlock) findAligned(size, align int) *treapBlock {
+	if b == nil {
+		return nil
+	}
+	if block := b.left.findAligned(size, align); block != nil {
+		return block
+	}
+	if alignUp(b.Start, align)+size <= b.End {
+		return b
+	}
+	return b.right.findAligned(size, align)
+}
+
+func (b *treapBlock) Walk(fn func(*treapBlock)) {
 	if b == nil {
 		return
 	}
@@ -129,20 +206,49 @@ func (b *Block) Walk(fn func(*Block)) {
 	b.right.Walk(fn)
 }
 
-func (a *Allocator) putBlock(block *Block) {
+func (a *Allocator) putBlock(block *treapBlock) {
 	block.right = nil
 	block.left = nil
 	block.parent = a.pool
 	a.pool = block
 }
 
+// find returns the block the fit policy picks for size bytes
+func (a *Allocator) find(size int) (block *treapBlock) {
+	switch a.Policy {
+	case FitBest:
+		return a.sizeTree.findBest(size)
+	case FitFirst:
+		return a.sizeTree.findFirst(size, 0)
+	case FitNext:
+		if block = a.sizeTree.findFirst(size, a.cursor); block == nil {
+			block = a.sizeTree.findFirst(size, 0)
+		}
+		return
+	case FitWorst:
+		if block = a.sizeTree.findLargest(); block != nil && block.End-block.Start < size {
+			block = nil
+		}
+		return
+	}
+	// the native search follows a single path and can miss a block that
+	// fits, so fall back to the pruned search before giving up
+	if block = a.sizeTree.find(size); block == nil {
+		block = a.sizeTree.findBest(size)
+	}
+	return
+}
+
 func (a *Allocator) Allocate(size int) (offset int) {
-	// a.history = append(a.history, History{Malloc: true, Size: size})
-	block := a.sizeTree.find(size)
+	if debugValidate {
+		defer mustValidate(a, "Allocate")
+	}
+	block := a.find(size)
 	if block == nil {
 		return -1
 	}
 	offset = block.Start
+	a.cursor = offset + size
 	a.deleteBlock(block)
 	if blockSize := block.End - block.Start; blockSize == size {
 		// Remove entire block
@@ -154,10 +260,68 @@ func (a *Allocator) Allocate(size int) (offset int) {
 	return offset
 }
 
-func (a *Allocator) deleteBlock(block *Block) {
-	// Rotate block down to leaf
+// AllocateAligned allocates size bytes at an offset that is a multiple of
+// align. The padding in front of the block stays in the free tree.
+func (a *Allocator) AllocateAligned(size, align int) (offset int) {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAligned")
+	}
+	if align <= 1 {
+		return a.Allocate(size)
+	}
+	// any block of size+align-1 fits whatever its alignment, otherwise fall
+	// back to scanning for a smaller block that happens to be aligned well
+	block := a.sizeTree.find(size + align - 1)
+	if block == nil {
+		if block = a.sizeTree.findAligned(size, align); block == nil {
+			return -1
+		}
+	}
+	offset = alignUp(block.Start, align)
+	a.take(block, offset, size)
+	return
+}
+
+// AllocateAt allocates exactly [offset, offset+size). It returns
+// ErrOutOfRange when the range is not inside the arena and ErrAllocated,
+// changing nothing, when any byte of it is already allocated.
+func (a *Allocator) AllocateAt(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAt")
+	}
+	if size <= 0 || offset < 0 || offset+size > a.Size {
+		return ErrOutOfRange
+	}
+	block, _ := a.neighbours(offset)
+	if block == nil || block.End < offset+size {
+		return ErrAllocated
+	}
+	a.take(block, offset, size)
+	return nil
+}
+
+// take allocates [offset, offset+size) from block, which contains it, and
+// puts the free remainders on either side back
+func (a *Allocator) take(block *treapBlock, offset, size int) {
+	a.deleteBlock(block)
+	if end := block.End; offset > block.Start {
+		block.End = offset
+		a.insert(block)
+		if tail := offset + size; tail < end {
+			a.insert(a.getBlock(tail, end))
+		}
+	} else if offset+size < end {
+		block.Start = offset + size
+		a.insert(block)
+	} else {
+		a.putBlock(block)
+	}
+}
+
+func (a *Allocator) deleteBlock(block *treapBlock) {
+	// Rotate block down to leaf, lifting the smaller child to keep the heap order
 	for block.left != nil || block.right != nil {
-		if block.right == nil || (block.left != nil && (block.left.End-block.left.Start) > (block.right.End-block.right.Start)) {
+		if block.right == nil || (block.left != nil && (block.left.End-block.left.Start) < (block.right.End-block.right.Start)) {
 			block.rotateRight(a)
 		} else {
 			block.rotateLeft(a)
@@ -171,29 +335,46 @@ func (a *Allocator) deleteBlock(block *Block) {
 		} else {
 			p.right = nil
 		}
+		for ; p != nil; p = p.parent {
+			p.update()
+		}
 	} else {
 		a.sizeTree = nil
 	}
 }
 
-func (a *Allocator) insert(block *Block) {
-	// a.sizeTree.Walk(func(b *Block) {
-	// 	if block.Start >= b.Start && block.Start < b.End {
-	// 		out, _ := yaml.Marshal(a.history)
-	// 		fmt.Println(string(out))
-	// 	}
-	// })
+func (a *Allocator) insert(block *treapBlock) {
+	block.maxSize = block.End - block.Start
 	a.sizeTree = a.sizeTree.insert(block, a)
 	// if a.sizeTree.parent != nil {
 	// 	panic("sizeTree parent is not nil")
 	// }
 }
 
-func (a *Allocator) Free(offset, size int) {
-	// a.history = append(a.history, History{Malloc: false, Offset: offset, Size: size})
+func (a *Allocator) Free(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "Free")
+	}
+	var left, right *treapBlock
+	prevEnd, nextStart := offset, offset+size
+	prev, next := a.neighbours(offset)
+	if prev != nil {
+		prevEnd = prev.End
+	}
+	if next != nil {
+		nextStart = next.Start
+	}
+	if err := checkFree(prevEnd, nextStart, offset, size); err != nil {
+		return err
+	}
 	// Try to merge with adjacent blocks
-	// Find adjacent blocks
-	switch left, right := a.findLeftAdjacent(offset), a.findRightAdjacent(offset+size); true {
+	if prevEnd == offset && prev != nil {
+		left = prev
+	}
+	if nextStart == offset+size && next != nil {
+		right = next
+	}
+	switch true {
 	case left != nil && right != nil:
 		a.deleteBlock(right)
 		a.deleteBlock(left)
@@ -212,9 +393,87 @@ func (a *Allocator) Free(offset, size int) {
 		right.Start = offset
 		a.insert(right)
 	}
+	return nil
 }
 
-func (a *Allocator) findLeftAdjacent(offset int) (curr *Block) {
+// neighbours returns the free blocks with the greatest Start not after
+// offset and the smallest Start after it
+func (a *Allocator) neighbours(offset int) (prev, next *treapBlock) {
+	for curr := a.sizeTree; curr != nil; {
+		if curr.Start <= offset {
+			prev, curr = curr, curr.right
+		} else {
+			next, curr = curr, curr.left
+		}
+	}
+	return
+}
+
+// Extend grows the allocation [offset, offset+oldSize) to newSize bytes in
+// place by taking the head of the adjacent free block on its right.
+// It reports false and changes nothing when that block is missing or too small.
+func (a *Allocator) Extend(offset, oldSize, newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Extend")
+	}
+	if newSize <= oldSize {
+		return newSize == oldSize
+	}
+	grow := newSize - oldSize
+	right := a.findRightAdjacent(offset + oldSize)
+	if right == nil || right.End-right.Start < grow {
+		return false
+	}
+	a.deleteBlock(right)
+	if right.End-right.Start == grow {
+		a.putBlock(right)
+	} else {
+		right.Start += grow
+		a.insert(right)
+	}
+	return true
+}
+
+func (a *Allocator) Grow(newSize int) {
+	if debugValidate {
+		defer mustValidate(a, "Grow")
+	}
+	if newSize <= a.Size {
+		return
+	}
+	if tail := a.findLeftAdjacent(a.Size); tail != nil {
+		a.deleteBlock(tail)
+		tail.End = newSize
+		a.insert(tail)
+	} else {
+		a.insert(a.getBlock(a.Size, newSize))
+	}
+	a.Size = newSize
+}
+
+func (a *Allocator) Shrink(newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Shrink")
+	}
+	if newSize <= 0 || newSize >= a.Size {
+		return newSize == a.Size
+	}
+	tail := a.findLeftAdjacent(a.Size)
+	if tail == nil || tail.Start > newSize {
+		return false
+	}
+	a.deleteBlock(tail)
+	if tail.Start == newSize {
+		a.putBlock(tail)
+	} else {
+		tail.End = newSize
+		a.insert(tail)
+	}
+	a.Size = newSize
+	return true
+}
+
+func (a *Allocator) findLeftAdjacent(offset int) (curr *treapBlock) {
 	curr = a.sizeTree
 	for curr != nil {
 		if curr.End == offset {
@@ -229,7 +488,7 @@ func (a *Allocator) findLeftAdjacent(offset int) (curr *Block) {
 	return
 }
 
-func (a *Allocator) findRightAdjacent(offset int) (curr *Block) {
+func (a *Allocator) findRightAdjacent(offset int) (curr *treapBlock) {
 	curr = a.sizeTree
 	for curr != nil {
 		if curr.Start == offset {
@@ -244,9 +503,9 @@ func (a *Allocator) findRightAdjacent(offset int) (curr *Block) {
 	return
 }
 
-func (a *Allocator) getBlock(start, end int) *Block {
+func (a *Allocator) getBlock(start, end int) *treapBlock {
 	if a.pool == nil {
-		return &Block{Start: start, End: end}
+		return &treapBlock{Block: Block{Start: start, End: end}}
 	} else {
 		block := a.pool
 		a.pool = block.parent
@@ -257,12 +516,19 @@ func (a *Allocator) getBlock(start, end int) *Block {
 }
 
 func (a *Allocator) GetFreeSize() (size int) {
-	a.sizeTree.Walk(func(b *Block) {
+	a.sizeTree.Walk(func(b *treapBlock) {
 		size += b.End - b.Start
 	})
 	return
 }
 
+func (a *Allocator) Stats() (stats FreeSpaceStats) {
+	a.sizeTree.Walk(func(b *treapBlock) {
+		stats.addBlock(b.End - b.Start)
+	})
+	return
+}
+
 func (a *Allocator) Recycle() {
 	a.sizeTree.Walk(a.putBlock)
 	a.sizeTree = nil
@@ -271,11 +537,17 @@ func (a *Allocator) Recycle() {
 
 func (a *Allocator) Init(size int) {
 	a.sizeTree = a.getBlock(0, size)
+	a.sizeTree.maxSize = size
 	a.Size = size
+	a.cursor = 0
+}
+
+func (a *Allocator) SetFitPolicy(policy FitPolicy) {
+	a.Policy = policy
 }
 
 func (a *Allocator) Find(size int) (offset int) {
-	block := a.sizeTree.find(size)
+	block := a.find(size)
 	if block == nil {
 		return -1
 	}
@@ -283,8 +555,16 @@ func (a *Allocator) Find(size int) (offset int) {
 }
 
 func (a *Allocator) GetBlocks() (blocks []*Block) {
-	a.sizeTree.Walk(func(b *Block) {
-		blocks = append(blocks, b)
+	a.sizeTree.Walk(func(b *treapBlock) {
+		blocks = append(blocks, &b.Block)
 	})
 	return
 }
+
+func (a *Allocator) IsEmpty() bool {
+	return a.sizeTree != nil && a.sizeTree.Start == 0 && a.sizeTree.End == a.Size
+}
+
+func (a *Allocator) Strategy() AllocatorStrategy {
+	return StrategyTreap
+}
@@ -1,5 +1,3 @@
-//go:build twotree
-
 package gomem
 
 const TreeIndexSize = 0
@@ -7,46 +5,52 @@ const TreeIndexOffset = 1
 
 type (
 	Tree struct {
-		left, right *Block
+		left, right *twoTreeBlock
 		height      int
+		maxSize     int // largest block size in this subtree
 	}
-	Block struct {
-		Start, End int
-		trees      [2]Tree
-	}
-	History struct {
-		Malloc bool
-		Offset int
-		Size   int
+	twoTreeBlock struct {
+		Block
+		trees [2]Tree
 	}
-	Allocator struct {
-		pool       *Block
-		sizeTree   *Block
-		offsetTree *Block
+	// TwoTreeAllocator is the AVL implementation of FreeSpaceIndex
+	// (StrategyTwoTree).
+	TwoTreeAllocator struct {
+		pool       *twoTreeBlock
+		sizeTree   *twoTreeBlock
+		offsetTree *twoTreeBlock
 		Size       int
-		//history    []History
+		Policy     FitPolicy
+		cursor     int // where FitNext resumes searching
 	}
 )
 
-func (t *Tree) deleteLeft(b *Block, treeIndex int) {
+func (t *Tree) deleteLeft(b *twoTreeBlock, treeIndex int) {
 	t.left = t.left.delete(b, treeIndex)
 }
 
-func (t *Tree) deleteRight(b *Block, treeIndex int) {
+func (t *Tree) deleteRight(b *twoTreeBlock, treeIndex int) {
 	t.right = t.right.delete(b, treeIndex)
 }
 
-func NewAllocator(size int) (result *Allocator) {
-	root := &Block{Start: 0, End: size}
-	result = &Allocator{
+// NewTwoTreeAllocator creates a two-tree allocator of size bytes, optionally
+// with a fit policy other than FitDefault.
+func NewTwoTreeAllocator(size int, policy ...FitPolicy) (result *TwoTreeAllocator) {
+	root := &twoTreeBlock{Block: Block{Start: 0, End: size}}
+	root.updateHeight(TreeIndexSize)
+	root.updateHeight(TreeIndexOffset)
+	result = &TwoTreeAllocator{
 		sizeTree:   root,
 		offsetTree: root,
 		Size:       size,
 	}
+	if len(policy) > 0 {
+		result.Policy = policy[0]
+	}
 	return
 }
 
-func compareBySize(a, b *Block) bool {
+func compareBySize(a, b *twoTreeBlock) bool {
 	//if a.Start == b.Start {
 	//	panic("duplicate block")
 	//}
@@ -56,18 +60,19 @@ func compareBySize(a, b *Block) bool {
 	return a.Start < b.Start
 }
 
-func compareByOffset(a, b *Block) bool {
+func compareByOffset(a, b *twoTreeBlock) bool {
 	//if a.Start == b.Start {
 	//	panic("duplicate block")
 	//}
 	return a.Start < b.Start
 }
 
-var compares = [...]func(a, b *Block) bool{compareBySize, compareByOffset}
+var compares = [...]func(a, b *twoTreeBlock) bool{compareBySize, compareByOffset}
 var emptyTrees = [2]Tree{}
 
-func (b *Block) insert(block *Block, treeIndex int) *Block {
+func (b *twoTreeBlock) insert(block *twoTreeBlock, treeIndex int) *twoTreeBlock {
 	if b == nil {
+		block.updateHeight(treeIndex)
 		return block
 	}
 	if b == block {
@@ -85,26 +90,35 @@ func (b *Block) insert(block *Block, treeIndex int) *Block {
 	return b.balance(treeIndex)
 }
 
-func (b *Block) getLeftHeight(treeIndex int) int {
+func (b *twoTreeBlock) getLeftHeight(treeIndex int) int {
 	return b.trees[treeIndex].left.getHeight(treeIndex)
 }
 
-func (b *Block) getRightHeight(treeIndex int) int {
+func (b *twoTreeBlock) getRightHeight(treeIndex int) int {
 	return b.trees[treeIndex].right.getHeight(treeIndex)
 }
 
-func (b *Block) getHeight(treeIndex int) int {
+func (b *twoTreeBlock) getHeight(treeIndex int) int {
 	if b == nil {
 		return 0
 	}
 	return b.trees[treeIndex].height
 }
 
-func (b *Block) updateHeight(treeIndex int) {
-	b.trees[treeIndex].height = 1 + max(b.getLeftHeight(treeIndex), b.getRightHeight(treeIndex))
+func (b *twoTreeBlock) getMaxSize(treeIndex int) int {
+	if b == nil {
+		return 0
+	}
+	return b.trees[treeIndex].maxSize
+}
+
+func (b *twoTreeBlock) updateHeight(treeIndex int) {
+	tree := &b.trees[treeIndex]
+	tree.height = 1 + max(b.getLeftHeight(treeIndex), b.getRightHeight(treeIndex))
+	tree.maxSize = max(b.End-b.Start, tree.left.getMaxSize(treeIndex), tree.right.getMaxSize(treeIndex))
 }
 
-func (b *Block) balance(treeIndex int) *Block {
+func (b *twoTreeBlock) balance(treeIndex int) *twoTreeBlock {
 	if b == nil {
 		return nil
 	}
@@ -122,7 +136,7 @@ func (b *Block) balance(treeIndex int) *Block {
 	return b
 }
 
-func (b *Block) rotateLeft(treeIndex int) *Block {
+func (b *twoTreeBlock) rotateLeft(treeIndex int) *twoTreeBlock {
 	newRoot := b.trees[treeIndex].right
 	b.trees[treeIndex].right = newRoot.trees[treeIndex].left
 	newRoot.trees[treeIndex].left = b
@@ -131,7 +145,7 @@ func (b *Block) rotateLeft(treeIndex int) *Block {
 	return newRoot
 }
 
-func (b *Block) rotateRight(treeIndex int) *Block {
+func (b *twoTreeBlock) rotateRight(treeIndex int) *twoTreeBlock {
 	newRoot := b.trees[treeIndex].left
 	b.trees[treeIndex].left = newRoot.trees[treeIndex].right
 	newRoot.trees[treeIndex].right = b
@@ -140,7 +154,7 @@ func (b *Block) rotateRight(treeIndex int) *Block {
 	return newRoot
 }
 
-func (b *Block) findMin(treeIndex int) *Block {
+func (b *twoTreeBlock) findMin(treeIndex int) *twoTreeBlock {
 	if left := b.trees[treeIndex].left; left == nil {
 		return b
 	} else {
@@ -148,7 +162,7 @@ func (b *Block) findMin(treeIndex int) *Block {
 	}
 }
 
-func (b *Block) delete(block *Block, treeIndex int) *Block {
+func (b *twoTreeBlock) delete(block *twoTreeBlock, treeIndex int) *twoTreeBlock {
 	if b == nil {
 		return nil
 	}
@@ -166,8 +180,9 @@ func (b *Block) delete(block *Block, treeIndex int) *Block {
 		minTree := &minBlock.trees[treeIndex]
 		minTree.left = tree.left
 		minTree.right = tree.right
-		minTree.height = tree.height
-		return minBlock
+		// the right subtree may have shrunk, so recompute rather than copy
+		minBlock.updateHeight(treeIndex)
+		return minBlock.balance(treeIndex)
 	} else if compareFunc(block, b) {
 		tree.deleteLeft(block, treeIndex)
 	} else {
@@ -177,15 +192,76 @@ func (b *Block) delete(block *Block, treeIndex int) *Block {
 	return b.balance(treeIndex)
 }
 
-func (a *Allocator) Init(size int) {
+func (a *TwoTreeAllocator) Init(size int) {
 	a.Size = size
 	root := a.getBlock(0, size)
+	root.updateHeight(TreeIndexSize)
+	root.updateHeight(TreeIndexOffset)
 	a.sizeTree = root
 	a.offsetTree = root
+	a.cursor = 0
+}
+
+func (a *TwoTreeAllocator) SetFitPolicy(policy FitPolicy) {
+	a.Policy = policy
+}
+
+// find returns the block the fit policy picks for size bytes
+func (a *TwoTreeAllocator) find(size int) (block *twoTreeBlock) {
+	switch a.Policy {
+	case FitFirst:
+		return a.offsetTree.findFirst(size, 0)
+	case FitNext:
+		if block = a.offsetTree.findFirst(size, a.cursor); block == nil {
+			block = a.offsetTree.findFirst(size, 0)
+		}
+		return
+	case FitWorst:
+		for block = a.sizeTree; block != nil && block.trees[TreeIndexSize].right != nil; {
+			block = block.trees[TreeIndexSize].right
+		}
+		if block != nil && block.End-block.Start < size {
+			block = nil
+		}
+		return
+	}
+	return a.findAvailableBlock(size)
+}
+
+// findFirst returns the lowest-offset block of at least size bytes that
+// starts at or after from
+func (b *twoTreeBlock) findFirst(size, from int) *twoTreeBlock {
+	if b == nil || b.trees[TreeIndexOffset].maxSize < size {
+		return nil
+	}
+	tree := &b.trees[TreeIndexOffset]
+	if b.Start >= from {
+		if block := tree.left.findFirst(size, from); block != nil {
+			return block
+		}
+		if b.End-b.Start >= size {
+			return b
+		}
+	}
+	return tree.right.findFirst(size, from)
+}
+
+// refresh recomputes maxSize in offsetTree along the path to block after
+// its End changed in place
+func (b *twoTreeBlock) refresh(block *twoTreeBlock) {
+	if b == nil {
+		return
+	}
+	if tree := &b.trees[TreeIndexOffset]; block.Start < b.Start {
+		tree.left.refresh(block)
+	} else if block.Start > b.Start {
+		tree.right.refresh(block)
+	}
+	b.updateHeight(TreeIndexOffset)
 }
 
-func (a *Allocator) Find(size int) (offset int) {
-	block := a.findAvailableBlock(size)
+func (a *TwoTreeAllocator) Find(size int) (offset int) {
+	block := a.find(size)
 	if block == nil {
 		return -1
 	}
@@ -193,13 +269,16 @@ func (a *Allocator) Find(size int) (offset int) {
 	return
 }
 
-func (a *Allocator) Allocate(size int) (offset int) {
-	//a.history = append(a.history, History{Malloc: true, Size: size})
-	block := a.findAvailableBlock(size)
+func (a *TwoTreeAllocator) Allocate(size int) (offset int) {
+	if debugValidate {
+		defer mustValidate(a, "Allocate")
+	}
+	block := a.find(size)
 	if block == nil {
 		return -1
 	}
 	offset = block.Start
+	a.cursor = offset + size
 	a.deleteSizeTree(block)
 	a.deleteOffsetTree(block)
 	if newStart := offset + size; newStart < block.End {
@@ -212,7 +291,90 @@ func (a *Allocator) Allocate(size int) (offset int) {
 	return
 }
 
-func (a *Allocator) findAvailableBlock(size int) (lastAvailableBlock *Block) {
+// AllocateAligned allocates size bytes at an offset that is a multiple of
+// align. The padding in front of the block stays in the free trees.
+func (a *TwoTreeAllocator) AllocateAligned(size, align int) (offset int) {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAligned")
+	}
+	if align <= 1 {
+		return a.Allocate(size)
+	}
+	block := a.sizeTree.findAligned(size, align)
+	if block == nil {
+		return -1
+	}
+	offset = alignUp(block.Start, align)
+	a.take(block, offset, size)
+	return
+}
+
+// AllocateAt allocates exactly [offset, offset+size). It returns
+// ErrOutOfRange when the range is not inside the arena and ErrAllocated,
+// changing nothing, when any byte of it is already allocated.
+func (a *TwoTreeAllocator) AllocateAt(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAt")
+	}
+	if size <= 0 || offset < 0 || offset+size > a.Size {
+		return ErrOutOfRange
+	}
+	block, _ := a.offsetTree.neighbours(offset)
+	if block == nil || block.End < offset+size {
+		return ErrAllocated
+	}
+	a.take(block, offset, size)
+	return nil
+}
+
+// take allocates [offset, offset+size) from block, which contains it, and
+// puts the free remainders on either side back
+func (a *TwoTreeAllocator) take(block *twoTreeBlock, offset, size int) {
+	if offset == block.Start {
+		a.deleteSizeTree(block)
+		a.deleteOffsetTree(block)
+		if newStart := offset + size; newStart < block.End {
+			block.Start = newStart
+			a.insertSizeTree(block)
+			a.insertOffsetTree(block)
+		} else {
+			a.putBlock(block)
+		}
+		return
+	}
+	// the head keeps its Start, so only its position in sizeTree changes
+	end := block.End
+	a.deleteSizeTree(block)
+	block.End = offset
+	a.insertSizeTree(block)
+	a.offsetTree.refresh(block)
+	if tail := offset + size; tail < end {
+		rest := a.getBlock(tail, end)
+		a.insertSizeTree(rest)
+		a.insertOffsetTree(rest)
+	}
+}
+
+// findAligned returns the smallest block that can hold size bytes at a
+// multiple of align
+func (b *twoTreeBlock) findAligned(size, align int) *twoTreeBlock {
+	if b == nil {
+		return nil
+	}
+	tree := &b.trees[TreeIndexSize]
+	if b.End-b.Start < size {
+		return tree.right.findAligned(size, align)
+	}
+	if block := tree.left.findAligned(size, align); block != nil {
+		return block
+	}
+	if alignUp(b.Start, align)+size <= b.End {
+		return b
+	}
+	return tree.right.findAligned(size, align)
+}
+
+func (a *TwoTreeAllocator) findAvailableBlock(size int) (lastAvailableBlock *twoTreeBlock) {
 	block := a.sizeTree
 	for block != nil {
 		if bSize := block.End - block.Start; bSize == size {
@@ -227,9 +389,9 @@ func (a *Allocator) findAvailableBlock(size int) (lastAvailableBlock *Block) {
 	return
 }
 
-func (a *Allocator) getBlock(start, end int) *Block {
+func (a *TwoTreeAllocator) getBlock(start, end int) *twoTreeBlock {
 	if a.pool == nil {
-		return &Block{Start: start, End: end}
+		return &twoTreeBlock{Block: Block{Start: start, End: end}}
 	} else {
 		block := a.pool
 		a.pool = block.trees[TreeIndexSize].left
@@ -239,21 +401,42 @@ func (a *Allocator) getBlock(start, end int) *Block {
 	}
 }
 
-func (a *Allocator) putBlock(b *Block) {
+func (a *TwoTreeAllocator) putBlock(b *twoTreeBlock) {
 	b.trees = emptyTrees
 	b.trees[TreeIndexSize].left = a.pool
 	a.pool = b
 }
 
-func (a *Allocator) Free(offset, size int) {
-	//a.history = append(a.history, History{Malloc: false, Offset: offset, Size: size})
-	switch leftAdjacent, rightAdjacent := a.offsetTree.findLeftAdjacentBlock(offset), a.offsetTree.findRightAdjacentBlock(offset+size); true {
+func (a *TwoTreeAllocator) Free(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "Free")
+	}
+	var leftAdjacent, rightAdjacent *twoTreeBlock
+	prevEnd, nextStart := offset, offset+size
+	prev, next := a.offsetTree.neighbours(offset)
+	if prev != nil {
+		prevEnd = prev.End
+	}
+	if next != nil {
+		nextStart = next.Start
+	}
+	if err := checkFree(prevEnd, nextStart, offset, size); err != nil {
+		return err
+	}
+	if prevEnd == offset && prev != nil {
+		leftAdjacent = prev
+	}
+	if nextStart == offset+size && next != nil {
+		rightAdjacent = next
+	}
+	switch true {
 	case leftAdjacent != nil && rightAdjacent != nil:
 		a.deleteOffsetTree(rightAdjacent)
 		a.deleteSizeTree(rightAdjacent)
 		a.deleteSizeTree(leftAdjacent)
 		leftAdjacent.End = rightAdjacent.End
 		a.insertSizeTree(leftAdjacent)
+		a.offsetTree.refresh(leftAdjacent)
 		a.putBlock(rightAdjacent)
 	case leftAdjacent == nil && rightAdjacent == nil:
 		block := a.getBlock(offset, offset+size)
@@ -263,6 +446,7 @@ func (a *Allocator) Free(offset, size int) {
 		a.deleteSizeTree(leftAdjacent)
 		leftAdjacent.End = offset + size
 		a.insertSizeTree(leftAdjacent)
+		a.offsetTree.refresh(leftAdjacent)
 	case rightAdjacent != nil:
 		a.deleteOffsetTree(rightAdjacent)
 		a.deleteSizeTree(rightAdjacent)
@@ -270,57 +454,145 @@ func (a *Allocator) Free(offset, size int) {
 		a.insertSizeTree(rightAdjacent)
 		a.insertOffsetTree(rightAdjacent)
 	}
+	return nil
+}
+
+// neighbours returns the free blocks with the greatest Start not after
+// offset and the smallest Start after it
+func (b *twoTreeBlock) neighbours(offset int) (prev, next *twoTreeBlock) {
+	for b != nil {
+		if tree := &b.trees[TreeIndexOffset]; b.Start <= offset {
+			prev, b = b, tree.right
+		} else {
+			next, b = b, tree.left
+		}
+	}
+	return
 }
 
-func (a *Allocator) GetBlocks() (blocks []*Block) {
-	a.offsetTree.Walk(func(block *Block) {
-		blocks = append(blocks, block)
+// Extend grows the allocation [offset, offset+oldSize) to newSize bytes in
+// place by taking the head of the adjacent free block on its right.
+// It reports false and changes nothing when that block is missing or too small.
+func (a *TwoTreeAllocator) Extend(offset, oldSize, newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Extend")
+	}
+	if newSize <= oldSize {
+		return newSize == oldSize
+	}
+	grow := newSize - oldSize
+	right := a.offsetTree.findRightAdjacentBlock(offset + oldSize)
+	if right == nil || right.End-right.Start < grow {
+		return false
+	}
+	a.deleteSizeTree(right)
+	a.deleteOffsetTree(right)
+	if right.End-right.Start == grow {
+		a.putBlock(right)
+	} else {
+		right.Start += grow
+		a.insertSizeTree(right)
+		a.insertOffsetTree(right)
+	}
+	return true
+}
+
+func (a *TwoTreeAllocator) Grow(newSize int) {
+	if debugValidate {
+		defer mustValidate(a, "Grow")
+	}
+	if newSize <= a.Size {
+		return
+	}
+	if tail := a.offsetTree.findLeftAdjacentBlock(a.Size); tail != nil {
+		a.deleteSizeTree(tail)
+		tail.End = newSize
+		a.insertSizeTree(tail)
+		a.offsetTree.refresh(tail)
+	} else {
+		block := a.getBlock(a.Size, newSize)
+		a.insertSizeTree(block)
+		a.insertOffsetTree(block)
+	}
+	a.Size = newSize
+}
+
+func (a *TwoTreeAllocator) Shrink(newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Shrink")
+	}
+	if newSize <= 0 || newSize >= a.Size {
+		return newSize == a.Size
+	}
+	tail := a.offsetTree.findLeftAdjacentBlock(a.Size)
+	if tail == nil || tail.Start > newSize {
+		return false
+	}
+	a.deleteSizeTree(tail)
+	if tail.Start == newSize {
+		a.deleteOffsetTree(tail)
+		a.putBlock(tail)
+	} else {
+		tail.End = newSize
+		a.insertSizeTree(tail)
+		a.offsetTree.refresh(tail)
+	}
+	a.Size = newSize
+	return true
+}
+
+func (a *TwoTreeAllocator) GetBlocks() (blocks []*Block) {
+	a.offsetTree.Walk(func(block *twoTreeBlock) {
+		blocks = append(blocks, &block.Block)
 	}, 1)
 	return
 }
 
-func (a *Allocator) GetFreeSize() (ret int) {
-	a.offsetTree.Walk(func(block *Block) {
+func (a *TwoTreeAllocator) IsEmpty() bool {
+	return a.offsetTree != nil && a.offsetTree.Start == 0 && a.offsetTree.End == a.Size
+}
+
+func (a *TwoTreeAllocator) Strategy() AllocatorStrategy {
+	return StrategyTwoTree
+}
+
+func (a *TwoTreeAllocator) GetFreeSize() (ret int) {
+	a.offsetTree.Walk(func(block *twoTreeBlock) {
 		ret += block.End - block.Start
 	}, 1)
 	return
 }
 
-func (a *Allocator) insertSizeTree(block *Block) {
+func (a *TwoTreeAllocator) Stats() (stats FreeSpaceStats) {
+	a.offsetTree.Walk(func(block *twoTreeBlock) {
+		stats.addBlock(block.End - block.Start)
+	}, 1)
+	return
+}
+
+func (a *TwoTreeAllocator) insertSizeTree(block *twoTreeBlock) {
 	//if block.End == block.Start {
 	//	panic("empty block")
 	//}
-	//a.sizeTree.Walk(func(b *Block) {
-	//	if block.Start >= b.Start && block.Start < b.End {
-	//		out, _ := yaml.Marshal(a.history)
-	//		fmt.Println(string(out))
-	//	}
-	//}, 0)
 	a.sizeTree = a.sizeTree.insert(block, TreeIndexSize)
 }
 
-func (a *Allocator) insertOffsetTree(block *Block) {
+func (a *TwoTreeAllocator) insertOffsetTree(block *twoTreeBlock) {
 	//if block.End == block.Start {
 	//	panic("empty block")
 	//}
-	//a.offsetTree.Walk(func(b *Block) {
-	//	if block.Start >= b.Start && block.Start < b.End {
-	//		out, _ := yaml.Marshal(a.history)
-	//		fmt.Println(string(out))
-	//	}
-	//}, 1)
 	a.offsetTree = a.offsetTree.insert(block, TreeIndexOffset)
 }
 
-func (a *Allocator) deleteSizeTree(block *Block) {
+func (a *TwoTreeAllocator) deleteSizeTree(block *twoTreeBlock) {
 	a.sizeTree = a.sizeTree.delete(block, TreeIndexSize)
 }
 
-func (a *Allocator) deleteOffsetTree(block *Block) {
+func (a *TwoTreeAllocator) deleteOffsetTree(block *twoTreeBlock) {
 	a.offsetTree = a.offsetTree.delete(block, TreeIndexOffset)
 }
 
-func (b *Block) findLeftAdjacentBlock(offset int) *Block {
+func (b *twoTreeBlock) findLeftAdjacentBlock(offset int) *twoTreeBlock {
 	for b != nil {
 		if b.End == offset {
 			return b
@@ -334,7 +606,7 @@ func (b *Block) findLeftAdjacentBlock(offset int) *Block {
 	return nil
 }
 
-func (b *Block) findRightAdjacentBlock(offset int) *Block {
+func (b *twoTreeBlock) findRightAdjacentBlock(offset int) *twoTreeBlock {
 	for b != nil {
 		if b.Start == offset {
 			return b
@@ -348,15 +620,15 @@ func (b *Block) findRightAdjacentBlock(offset int) *Block {
 	return nil
 }
 
-func (a *Allocator) Recycle() {
-	a.sizeTree.Walk(func(block *Block) {
+func (a *TwoTreeAllocator) Recycle() {
+	a.sizeTree.Walk(func(block *twoTreeBlock) {
 		a.putBlock(block)
 	}, 0)
 	a.sizeTree = nil
 	a.offsetTree = nil
 }
 
-func (b *Block) Walk(fn func(*Block), index int) {
+func (b *twoTreeBlock) Walk(fn func(*twoTreeBlock), index int) {
 	if b == nil {
 		return
 	}
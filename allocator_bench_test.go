@@ -94,3 +94,41 @@ func BenchmarkAllocatorGetFreeSize(b *testing.B) {
 		_ = allocator.GetFreeSize()
 	}
 }
+
+// BenchmarkFitPolicyFragmentation runs a mixed-size workload under each fit
+// policy and reports the fragmentation of the free space left behind
+func BenchmarkFitPolicyFragmentation(b *testing.B) {
+	sizes := []int{64, 4096, 256, 1024, 128, 2048, 512}
+	for _, strategy := range []AllocatorStrategy{StrategyTreap, StrategyTwoTree} {
+		for _, policy := range []FitPolicy{FitDefault, FitBest, FitFirst, FitNext, FitWorst} {
+			b.Run(strategy.String()+"/"+policy.String(), func(b *testing.B) {
+				allocator := NewFreeSpaceIndex(strategy, 1024*1024)
+				allocator.SetFitPolicy(policy)
+				var live [][2]int
+				failures := 0
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// keep about 256 allocations alive, freeing every third one
+					if len(live) > 256 || (i%3 == 0 && len(live) > 0) {
+						j := (i * 7919) % len(live)
+						allocator.Free(live[j][0], live[j][1])
+						live[j] = live[len(live)-1]
+						live = live[:len(live)-1]
+						continue
+					}
+					size := sizes[i%len(sizes)] + i%61
+					if offset := allocator.Allocate(size); offset != -1 {
+						live = append(live, [2]int{offset, size})
+					} else {
+						failures++
+					}
+				}
+				b.StopTimer()
+				stats := allocator.Stats()
+				b.ReportMetric(stats.Fragmentation(), "frag")
+				b.ReportMetric(float64(stats.FreeBlocks), "free-blocks")
+				b.ReportMetric(float64(failures), "failures")
+			})
+		}
+	}
+}
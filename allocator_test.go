@@ -1,53 +1,69 @@
 package gomem
 
 import (
+	"errors"
+	"math/rand/v2"
+	"os"
 	"slices"
 	"testing"
-
-	"gopkg.in/yaml.v3"
+	"unsafe"
 )
 
+var strategies = []AllocatorStrategy{StrategyTreap, StrategyTwoTree, StrategyTLSF}
+
 func TestAllocator(t *testing.T) {
-	allocator := NewAllocator(1000)
+	for _, strategy := range strategies {
+		t.Run(strategy.String(), func(t *testing.T) {
+			allocator := NewFreeSpaceIndex(strategy, 1000)
 
-	// Allocate memory
-	block1 := allocator.Allocate(100)
-	if block1 != 0 {
-		t.Error("Failed to allocate memory")
-	}
+			// Allocate memory
+			block1 := allocator.Allocate(100)
+			if block1 != 0 {
+				t.Error("Failed to allocate memory")
+			}
 
-	// Allocate memory
-	block2 := allocator.Allocate(200)
-	if block2 != 100 {
-		t.Error("Failed to allocate memory")
-	}
+			// Allocate memory
+			block2 := allocator.Allocate(200)
+			if block2 != 100 {
+				t.Error("Failed to allocate memory")
+			}
 
-	// Free memory
-	allocator.Free(0, 299)
-	if allocator.GetFreeSize() != 999 {
-		t.Error("Failed to free memory")
-	}
-	allocator.Free(299, 1)
+			// Free memory
+			allocator.Free(0, 299)
+			if allocator.GetFreeSize() != 999 {
+				t.Error("Failed to free memory")
+			}
+			allocator.Free(299, 1)
 
-	// Reallocate memory
-	block3 := allocator.Allocate(50)
-	if block3 != 0 {
-		t.Error("Failed to allocate memory")
-	}
+			// Reallocate memory
+			block3 := allocator.Allocate(50)
+			if block3 != 0 {
+				t.Error("Failed to allocate memory")
+			}
 
-	// Free memory
-	allocator.Free(0, 50)
+			// Free memory
+			allocator.Free(0, 50)
 
-	// Allocate memory larger than available space
-	block4 := allocator.Allocate(1000)
-	if block4 != 0 {
-		t.Error("Should not allocate memory larger than available space")
+			// Allocate memory larger than available space
+			block4 := allocator.Allocate(1000)
+			if block4 != 0 {
+				t.Error("Should not allocate memory larger than available space")
+			}
+		})
 	}
 }
 
 func FuzzAllocator(f *testing.F) {
+	fuzzFreeSpaceIndex(f, NewAllocator(65535), 65535)
+}
+
+func FuzzTLSFAllocator(f *testing.F) {
+	fuzzFreeSpaceIndex(f, NewTLSFAllocator(65535), 65535)
+}
+
+func fuzzFreeSpaceIndex(f *testing.F, allocator FreeSpaceIndex, arenaSize int) {
+	f.Add(100, true)
 	f.Add(100, false)
-	allocator := NewAllocator(65535)
 	var used [][2]int
 	var totalMalloc, totalFree int = 0, 0
 	f.Fuzz(func(t *testing.T, size int, alloc bool) {
@@ -58,8 +74,8 @@ func FuzzAllocator(f *testing.F) {
 		t.Logf("totalFree:%d,size:%d, free:%v", totalFree, size, free)
 		defer func() {
 			t.Logf("totalMalloc:%d, totalFree:%d, freeSize:%d", totalMalloc, totalFree, allocator.GetFreeSize())
-			if totalMalloc-totalFree != allocator.Size-allocator.GetFreeSize() {
-				t.Logf("totalUsed:%d, used:%d", totalMalloc-totalFree, allocator.Size-allocator.GetFreeSize())
+			if totalMalloc-totalFree != arenaSize-allocator.GetFreeSize() {
+				t.Logf("totalUsed:%d, used:%d", totalMalloc-totalFree, arenaSize-allocator.GetFreeSize())
 				t.FailNow()
 			}
 		}()
@@ -67,8 +83,8 @@ func FuzzAllocator(f *testing.F) {
 			if len(used) == 0 {
 				return
 			}
-			for _, u := range used {
-				if u[1] > size {
+			for i := range used {
+				if u := &used[i]; u[1] > size {
 					totalFree += size
 					t.Logf("totalFree1:%d, free:%v", totalFree, size)
 					allocator.Free(u[0], size)
@@ -93,82 +109,488 @@ func FuzzAllocator(f *testing.F) {
 	})
 }
 
-const testData = `
-- malloc: true
-  offset: 0
-  size: 16384
-- malloc: false
-  offset: 139
-  size: 16245
-- malloc: false
-  offset: 0
-  size: 50
-- malloc: false
-  offset: 50
-  size: 31
-- malloc: false
-  offset: 81
-  size: 9
-- malloc: false
-  offset: 90
-  size: 26
-- malloc: false
-  offset: 116
-  size: 21
-- malloc: false
-  offset: 137
-  size: 2
-- malloc: true
-  offset: 0
-  size: 16384
-- malloc: false
-  offset: 277
-  size: 16107
-- malloc: true
-  offset: 0
-  size: 16384
-- malloc: false
-  offset: 432
-  size: 16229
-- malloc: false
-  offset: 0
-  size: 277
-- malloc: false
-  offset: 277
-  size: 58
-- malloc: false
-  offset: 335
-  size: 60
-- malloc: false
-  offset: 395
-  size: 9
-- malloc: false
-  offset: 404
-  size: 26
-- malloc: true
-  offset: 0
-  size: 16384
-- malloc: false
-  offset: 557
-  size: 16259
-- malloc: false
-  offset: 430
-  size: 2
-`
-
-var history []History
-
-func init() {
-	yaml.Unmarshal([]byte(testData), &history)
+// TestAllocatorUseData replays testdata/history.trace, a TraceYAML trace of
+// one arena, with every strategy
+func TestAllocatorUseData(t *testing.T) {
+	f, err := os.Open("testdata/history.trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, strategy := range strategies {
+		report := ReplayTrace(events, strategy)
+		if len(report.Divergences) != 0 || report.InUse != 125 || report.PeakInUse != 16661 {
+			t.Fatalf("%v: divergences %v, in use %d, peak %d", strategy, report.Divergences, report.InUse, report.PeakInUse)
+		}
+	}
 }
 
-func TestAllocatorUseData(t *testing.T) {
-	allocator := NewAllocator(65535)
-	for _, h := range history {
-		if h.Malloc {
-			allocator.Allocate(h.Size)
+func TestScalableMemoryAllocatorStrategy(t *testing.T) {
+	for _, strategy := range strategies {
+		sma := NewScalableMemoryAllocator(1<<10, WithStrategy(strategy))
+		bufs := [][]byte{sma.Malloc(512), sma.Malloc(2048), sma.Malloc(100)}
+		for _, child := range sma.GetChildren() {
+			if got := child.allocator.Strategy(); got != strategy {
+				t.Fatalf("child strategy = %v, want %v", got, strategy)
+			}
+		}
+		for _, buf := range bufs {
+			if !sma.Free(buf) {
+				t.Fatalf("%v: free failed", strategy)
+			}
+		}
+		if inUse := sma.GetTotalMalloc() - sma.GetTotalFree(); inUse != 0 {
+			t.Fatalf("%v: in use = %d, want 0", strategy, inUse)
+		}
+		sma.Recycle()
+	}
+}
+
+func TestAllocatorAligned(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1<<16)
+		allocator.Allocate(3)
+		offset := allocator.AllocateAligned(100, 64)
+		if offset != 64 {
+			t.Fatalf("%v: offset = %d, want 64", strategy, offset)
+		}
+		// the padding [3, 64) must be back in the free tree
+		if got := allocator.Allocate(61); got != 3 {
+			t.Fatalf("%v: padding offset = %d, want 3", strategy, got)
+		}
+		if free := allocator.GetFreeSize(); free != 1<<16-164 {
+			t.Fatalf("%v: free = %d, want %d", strategy, free, 1<<16-164)
+		}
+		if offset = allocator.AllocateAligned(1<<16, 4096); offset != -1 {
+			t.Fatalf("%v: oversized offset = %d, want -1", strategy, offset)
+		}
+	}
+}
+
+func TestScalableMemoryAllocatorMallocAligned(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 14)
+	defer sma.Recycle()
+	for _, align := range []int{64, 512, 4096} {
+		sma.Malloc(7)
+		mem := sma.MallocAligned(1000, align)
+		if addr := uintptr(unsafe.Pointer(&mem[0])); addr%uintptr(align) != 0 || len(mem) != 1000 {
+			t.Fatalf("align %d: addr %x len %d", align, addr, len(mem))
+		}
+		if !sma.Free(mem) {
+			t.Fatalf("align %d: free failed", align)
+		}
+	}
+	var free int
+	for _, child := range sma.GetChildren() {
+		free += child.allocator.GetFreeSize()
+	}
+	if inUse := int(sma.GetTotalMalloc() - sma.GetTotalFree()); free != sma.size-inUse {
+		t.Fatalf("free = %d, want %d", free, sma.size-inUse)
+	}
+}
+
+func TestAllocatorExtend(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1000)
+		a := allocator.Allocate(100)
+		b := allocator.Allocate(100)
+		allocator.Free(b, 100)
+		if !allocator.Extend(a, 100, 150) {
+			t.Fatalf("%v: extend into free neighbour failed", strategy)
+		}
+		if got := allocator.Allocate(50); got != 150 {
+			t.Fatalf("%v: next offset = %d, want 150", strategy, got)
+		}
+		if allocator.Extend(a, 150, 250) {
+			t.Fatalf("%v: extend over allocated memory succeeded", strategy)
+		}
+		if free := allocator.GetFreeSize(); free != 800 {
+			t.Fatalf("%v: free = %d, want 800", strategy, free)
+		}
+	}
+}
+
+func TestScalableMemoryAllocatorRealloc(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 14)
+	defer sma.Recycle()
+	mem := sma.Malloc(100)
+	copy(mem, "hello")
+	grown := sma.Realloc(mem, 1000)
+	if &grown[0] != &mem[0] || string(grown[:5]) != "hello" {
+		t.Fatal("realloc did not grow in place")
+	}
+	blocker := sma.Malloc(10)
+	moved := sma.Realloc(grown, 2000)
+	if &moved[0] == &grown[0] || string(moved[:5]) != "hello" || len(moved) != 2000 {
+		t.Fatal("realloc did not move the buffer")
+	}
+	moved = sma.Realloc(moved, 10)
+	sma.Free(moved)
+	sma.Free(blocker)
+	if inUse := sma.GetTotalMalloc() - sma.GetTotalFree(); inUse != 0 {
+		t.Fatalf("in use = %d, want 0", inUse)
+	}
+}
+
+func TestAllocatorStats(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1000)
+		for range 5 {
+			allocator.Allocate(100)
+		}
+		allocator.Free(0, 100)
+		allocator.Free(200, 100)
+		stats := allocator.Stats()
+		if stats.FreeSize != 700 || stats.FreeBlocks != 3 || stats.LargestFree != 500 {
+			t.Fatalf("%v: stats %+v", strategy, stats)
+		}
+		if stats.Histogram[6] != 2 || stats.Histogram[8] != 1 {
+			t.Fatalf("%v: histogram %v", strategy, stats.Histogram)
+		}
+		if f := stats.Fragmentation(); f < 0.285 || f > 0.286 {
+			t.Fatalf("%v: fragmentation %f", strategy, f)
+		}
+	}
+}
+
+func TestScalableMemoryAllocatorStats(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 10)
+	defer sma.Recycle()
+	a, b := sma.Malloc(600), sma.Malloc(600)
+	sma.Free(a)
+	stats := sma.Stats()
+	if stats.InUse != 600 || stats.Children != len(sma.GetChildren()) {
+		t.Fatalf("stats %+v", stats)
+	}
+	if stats.Free.FreeSize != stats.Reserved-600 {
+		t.Fatalf("free %d, reserved %d", stats.Free.FreeSize, stats.Reserved)
+	}
+	sma.Free(b)
+}
+
+var fitPolicies = []FitPolicy{FitDefault, FitBest, FitFirst, FitNext, FitWorst}
+
+func TestFreeSpaceIndexRandom(t *testing.T) {
+	for _, strategy := range strategies {
+		for _, policy := range fitPolicies {
+			t.Run(strategy.String()+"/"+policy.String(), func(t *testing.T) {
+				testFreeSpaceIndexRandom(t, strategy, policy)
+			})
+		}
+	}
+}
+
+func testFreeSpaceIndexRandom(t *testing.T, strategy AllocatorStrategy, policy FitPolicy) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	allocator := NewFreeSpaceIndex(strategy, 1<<16)
+	allocator.SetFitPolicy(policy)
+	var used [][2]int
+	inUse := 0
+	for i := range 5000 {
+		if len(used) > 0 && rng.IntN(2) == 0 {
+			j := rng.IntN(len(used))
+			allocator.Free(used[j][0], used[j][1])
+			inUse -= used[j][1]
+			used = slices.Delete(used, j, j+1)
 		} else {
-			allocator.Free(h.Offset, h.Size)
+			size := 1 + rng.IntN(2000)
+			var want func(int) bool
+			if strategy != StrategyTLSF {
+				want = expectedFit(allocator.GetBlocks(), size, policy)
+			}
+			if offset := allocator.Allocate(size); offset != -1 {
+				if want != nil && !want(offset) {
+					t.Fatalf("step %d: %v fit of %d returned %d", i, policy, size, offset)
+				}
+				used = append(used, [2]int{offset, size})
+				inUse += size
+			}
+		}
+		if free := allocator.GetFreeSize(); free != 1<<16-inUse {
+			t.Fatalf("step %d: free %d, want %d", i, free, 1<<16-inUse)
+		}
+		if err := allocator.Validate(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+}
+
+// expectedFit returns a check of the offset that policy should pick among
+// blocks, or nil when any fitting block will do
+func expectedFit(blocks []*Block, size int, policy FitPolicy) func(offset int) bool {
+	var first, best, worst *Block
+	// copy the blocks, the index reuses their nodes
+	free := make([]Block, len(blocks))
+	for i, b := range blocks {
+		free[i] = *b
+	}
+	for i := range free {
+		b := &free[i]
+		if n := b.End - b.Start; n >= size {
+			if first == nil {
+				first = b
+			}
+			if best == nil || n < best.End-best.Start {
+				best = b
+			}
+			if worst == nil || n > worst.End-worst.Start {
+				worst = b
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	sizeIs := func(want *Block) func(int) bool {
+		return func(offset int) bool {
+			i := slices.IndexFunc(free, func(b Block) bool { return b.Start == offset })
+			return i != -1 && free[i].End-free[i].Start == want.End-want.Start
+		}
+	}
+	switch policy {
+	case FitFirst:
+		return func(offset int) bool { return offset == first.Start }
+	case FitBest:
+		return sizeIs(best)
+	case FitWorst:
+		return sizeIs(worst)
+	}
+	return nil
+}
+
+func TestAllocatorFitPolicy(t *testing.T) {
+	// free blocks of 300 at 0, 100 at 400 and 500 at 600
+	setup := func(strategy AllocatorStrategy, policy FitPolicy) FreeSpaceIndex {
+		allocator := NewFreeSpaceIndex(strategy, 1100)
+		allocator.Allocate(1100)
+		allocator.Free(0, 300)
+		allocator.Free(400, 100)
+		allocator.Free(600, 500)
+		allocator.SetFitPolicy(policy)
+		return allocator
+	}
+	for _, strategy := range []AllocatorStrategy{StrategyTreap, StrategyTwoTree} {
+		for _, tc := range []struct {
+			policy FitPolicy
+			want   []int // offsets of successive Allocate(100)
+		}{
+			{FitBest, []int{400, 0, 100}},
+			{FitFirst, []int{0, 100, 200}},
+			{FitNext, []int{0, 100, 200, 400, 600}},
+			{FitWorst, []int{600, 700}},
+		} {
+			allocator := setup(strategy, tc.policy)
+			for i, want := range tc.want {
+				if got := allocator.Allocate(100); got != want {
+					t.Fatalf("%v/%v: allocation %d at %d, want %d", strategy, tc.policy, i, got, want)
+				}
+			}
+		}
+		// next fit wraps around to the start of the arena
+		allocator := setup(strategy, FitNext)
+		allocator.Allocate(500)
+		if got := allocator.Allocate(200); got != 0 {
+			t.Fatalf("%v/next: wrapped allocation at %d, want 0", strategy, got)
+		}
+		if allocator.Allocate(600) != -1 {
+			t.Fatalf("%v: allocated more than the largest block", strategy)
+		}
+	}
+}
+
+func TestFreeSpaceIndexValidate(t *testing.T) {
+	corruptions := map[AllocatorStrategy][]func(FreeSpaceIndex){
+		StrategyTreap: {
+			func(index FreeSpaceIndex) { index.(*Allocator).sizeTree.maxSize++ },
+			func(index FreeSpaceIndex) {
+				root := index.(*Allocator).sizeTree
+				root.left, root.right = root.right, root.left
+			},
+		},
+		StrategyTwoTree: {
+			func(index FreeSpaceIndex) { index.(*TwoTreeAllocator).sizeTree.trees[TreeIndexSize].height++ },
+			func(index FreeSpaceIndex) {
+				root := index.(*TwoTreeAllocator).offsetTree
+				root.trees[TreeIndexOffset].right = root
+			},
+			func(index FreeSpaceIndex) { index.(*TwoTreeAllocator).offsetTree = nil },
+		},
+		StrategyTLSF: {
+			func(index FreeSpaceIndex) { index.(*TLSFAllocator).flBitmap = 0 },
+			func(index FreeSpaceIndex) { index.(*TLSFAllocator).ends = &tlsfNode{} },
+			func(index FreeSpaceIndex) { index.(*TLSFAllocator).freeSize++ },
+		},
+	}
+	for _, strategy := range strategies {
+		for i, corruptIndex := range corruptions[strategy] {
+			allocator := NewFreeSpaceIndex(strategy, 1000)
+			for range 5 {
+				allocator.Allocate(100)
+			}
+			allocator.Free(0, 100)
+			allocator.Free(200, 100)
+			if err := allocator.Validate(); err != nil {
+				t.Fatalf("%v: %v", strategy, err)
+			}
+			corruptIndex(allocator)
+			if err := allocator.Validate(); !errors.Is(err, ErrCorruptIndex) {
+				t.Fatalf("%v corruption %d: got %v", strategy, i, err)
+			}
+		}
+	}
+	// blocks that touch must have been coalesced
+	blocks := []*Block{{Start: 0, End: 10}, {Start: 10, End: 20}}
+	if err := validateBlocks(blocks, 20); !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("uncoalesced blocks: got %v", err)
+	}
+}
+
+func TestAllocatorDoubleFree(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1000)
+		allocator.Allocate(1000)
+		allocator.Free(200, 200) // free block [200, 400)
+		cases := []struct {
+			offset, size int
+			want         error
+		}{
+			{200, 200, ErrDoubleFree},
+			{300, 100, ErrDoubleFree},
+			{200, 50, ErrDoubleFree},
+			{250, 50, ErrDoubleFree},
+			{100, 200, ErrOverlap},
+			{200, 300, ErrOverlap},
+			{300, 200, ErrOverlap},
+			{100, 400, ErrOverlap},
+			{100, 100, nil},
+			{400, 100, nil},
+		}
+		for _, c := range cases {
+			if err := allocator.Free(c.offset, c.size); err != c.want {
+				t.Errorf("%v: Free(%d, %d) = %v, want %v", strategy, c.offset, c.size, err, c.want)
+			}
+		}
+		if free := allocator.GetFreeSize(); free != 400 {
+			t.Fatalf("%v: free %d after rejected frees, want 400", strategy, free)
+		}
+		if err := allocator.Validate(); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
+		}
+	}
+}
+
+// TestAllocatorPartialOverlap frees ranges that share no boundary with the
+// free block they overlap
+func TestAllocatorPartialOverlap(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 100)
+		allocator.Allocate(100)
+		allocator.Free(0, 10) // free block [0, 10)
+		if err := allocator.Free(5, 10); err != ErrOverlap {
+			t.Errorf("%v: Free(5, 10) over [0, 10) = %v", strategy, err)
+		}
+		allocator.Free(10, 10)
+		allocator.AllocateAt(0, 10) // free block [10, 20)
+		if err := allocator.Free(0, 30); err != ErrOverlap {
+			t.Errorf("%v: Free(0, 30) over [10, 20) = %v", strategy, err)
+		}
+		if err := allocator.Validate(); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
+		}
+		if free := allocator.GetFreeSize(); free != 10 {
+			t.Fatalf("%v: free %d after rejected frees, want 10", strategy, free)
+		}
+	}
+}
+
+func TestScalableMemoryAllocatorTryFree(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 10)
+	defer sma.Recycle()
+	a, b := sma.Malloc(100), sma.Malloc(100)
+	if err := sma.TryFree(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := sma.TryFree(a); err != ErrDoubleFree {
+		t.Fatalf("second free: %v", err)
+	}
+	if err := sma.TryFree(make([]byte, 10)); err != ErrNotOwned {
+		t.Fatalf("foreign free: %v", err)
+	}
+	if sma.GetTotalFree() != 100 {
+		t.Fatalf("total free %d, want 100", sma.GetTotalFree())
+	}
+	sma.Free(b)
+}
+
+func TestAllocatorAllocateAt(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1000)
+		steps := []struct {
+			offset, size int
+			want         error
+		}{
+			{0, 100, nil},            // head of the arena
+			{500, 100, nil},          // middle, leaving two remainders
+			{900, 100, nil},          // tail
+			{50, 100, ErrAllocated},  // overlaps [0, 100)
+			{450, 100, ErrAllocated}, // overlaps [500, 600)
+			{100, 400, nil},          // exactly a whole free block
+			{950, 100, ErrOutOfRange},
+			{-1, 10, ErrOutOfRange},
+			{600, 0, ErrOutOfRange},
+		}
+		for _, s := range steps {
+			if err := allocator.AllocateAt(s.offset, s.size); err != s.want {
+				t.Fatalf("%v: AllocateAt(%d, %d) = %v, want %v", strategy, s.offset, s.size, err, s.want)
+			}
+			if err := allocator.Validate(); err != nil {
+				t.Fatalf("%v: %v", strategy, err)
+			}
+		}
+		blocks := allocator.GetBlocks()
+		if len(blocks) != 1 || *blocks[0] != (Block{Start: 600, End: 900}) {
+			t.Fatalf("%v: free blocks %v", strategy, blocks)
+		}
+		if offset := allocator.Allocate(300); offset != 600 {
+			t.Fatalf("%v: allocated at %d after AllocateAt, want 600", strategy, offset)
+		}
+	}
+}
+
+func TestAllocatorGrowShrink(t *testing.T) {
+	for _, strategy := range strategies {
+		allocator := NewFreeSpaceIndex(strategy, 1000)
+		allocator.AllocateAt(0, 900)
+		allocator.Grow(2000) // coalesces with the free tail [900, 1000)
+		if blocks := allocator.GetBlocks(); len(blocks) != 1 || *blocks[0] != (Block{Start: 900, End: 2000}) {
+			t.Fatalf("%v: blocks after Grow %v", strategy, blocks)
+		}
+		if offset := allocator.Allocate(1100); offset != 900 {
+			t.Fatalf("%v: allocated at %d after Grow, want 900", strategy, offset)
+		}
+		allocator.Grow(3000) // no free tail
+		if blocks := allocator.GetBlocks(); len(blocks) != 1 || *blocks[0] != (Block{Start: 2000, End: 3000}) {
+			t.Fatalf("%v: blocks after second Grow %v", strategy, blocks)
+		}
+		if allocator.Shrink(1500) {
+			t.Fatalf("%v: shrank over allocated bytes", strategy)
+		}
+		if !allocator.Shrink(2500) || !allocator.Shrink(2000) {
+			t.Fatalf("%v: could not shrink a free tail", strategy)
+		}
+		if free := allocator.GetFreeSize(); free != 0 {
+			t.Fatalf("%v: free %d after shrinking to the allocations", strategy, free)
+		}
+		allocator.Free(0, 2000)
+		if !allocator.IsEmpty() {
+			t.Fatalf("%v: not empty after freeing everything", strategy)
+		}
+		if err := allocator.Validate(); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
 		}
 	}
 }
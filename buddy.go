@@ -2,16 +2,20 @@ package gomem
 
 import (
 	"errors"
+	"math/bits"
+	"runtime"
 	"sync"
 	"unsafe"
 )
 
 type Buddy struct {
-	size       int
-	longests   [BuddySize>>(MinPowerOf2-1) - 1]int
-	memoryPool [BuddySize]byte
+	size       int // number of 1<<minOrder units in the pool
+	minOrder   int
+	longests   []int
+	memoryPool []byte
 	poolStart  int64
-	lock       sync.Mutex // protects concurrent access to longests array
+	release    func() error // unmaps a pool created by mmap
+	lock       sync.Mutex   // protects concurrent access to longests array
 }
 
 var (
@@ -25,22 +29,66 @@ var (
 )
 
 // GetBuddy gets a Buddy instance from the pool
+//
+// Deprecated: the package no longer uses it; GetMemoryAllocator carves
+// children from a BuddyArena. Use NewBuddy or NewBuddyWithConfig.
 func GetBuddy() *Buddy {
 	buddy := buddyPool.Get().(*Buddy)
 	return buddy
 }
 
 // PutBuddy puts a Buddy instance back to the pool
+//
+// Deprecated: the package no longer uses it, see GetBuddy.
 func PutBuddy(b *Buddy) {
 	buddyPool.Put(b)
 }
 
-// NewBuddy creates a buddy instance.
-// If the parameter isn't valid, return the nil and error as well
+// NewBuddy creates a buddy instance of BuddySize bytes on the Go heap, in
+// units of 1<<MinPowerOf2 bytes.
 func NewBuddy() *Buddy {
-	size := BuddySize >> MinPowerOf2
+	return newBuddy(make([]byte, BuddySize), MinPowerOf2)
+}
+
+// NewBuddyWithConfig creates a buddy managing totalSize bytes in units of
+// 1<<minOrder bytes. totalSize must be a power of two no smaller than one
+// unit. The pool is the first totalSize bytes of pool, or an anonymous
+// mapping when pool is nil; release the mapping with Close.
+func NewBuddyWithConfig(totalSize, minOrder int, pool []byte) (*Buddy, error) {
+	if err := checkBuddyConfig(totalSize, minOrder); err != nil {
+		return nil, err
+	}
+	if pool != nil {
+		if len(pool) < totalSize {
+			return nil, InValidParameterErr
+		}
+		return newBuddy(pool[:totalSize:totalSize], minOrder), nil
+	}
+	pool, release, err := mmapPool(totalSize)
+	if err != nil {
+		return nil, err
+	}
+	ret := newBuddy(pool, minOrder)
+	ret.release = release
+	// unmap the pool if the caller never calls Close
+	runtime.SetFinalizer(ret, (*Buddy).Close)
+	return ret, nil
+}
+
+func checkBuddyConfig(totalSize, minOrder int) error {
+	if minOrder < 0 || minOrder >= 48 || totalSize < 1<<minOrder || !isPowerOf2(totalSize) {
+		return InValidParameterErr
+	}
+	return nil
+}
+
+func newBuddy(pool []byte, minOrder int) *Buddy {
+	size := len(pool) >> minOrder
 	ret := &Buddy{
-		size: size,
+		size:       size,
+		minOrder:   minOrder,
+		longests:   make([]int, 2*size-1),
+		memoryPool: pool,
 	}
 	for nodeSize, i := 2*size, 0; i < len(ret.longests); i++ {
 		if isPowerOf2(i + 1) {
@@ -53,6 +101,33 @@ func NewBuddy() *Buddy {
 	return ret
 }
 
+// MinOrder returns the log2 of the unit Alloc and Free count in.
+func (b *Buddy) MinOrder() int {
+	return b.minOrder
+}
+
+// PoolSize returns the number of bytes managed by the buddy.
+func (b *Buddy) PoolSize() int {
+	return len(b.memoryPool)
+}
+
+// Close unmaps a pool created by NewBuddyWithConfig. Memory handed out by
+// the buddy must not be used afterwards. It does nothing for other pools.
+func (b *Buddy) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.release == nil {
+		return nil
+	}
+	runtime.SetFinalizer(b, nil)
+	release := b.release
+	b.release = nil
+	b.size = 0
+	b.longests = []int{0}
+	b.memoryPool = nil
+	return release()
+}
+
 // Alloc find a unused block according to the size
 // return the offset of the block(regard 0 as the beginning)
 // and parameter error if any
@@ -121,6 +196,59 @@ func (b *Buddy) Free(offset int) error {
 	return nil
 }
 
+// Stats returns the free blocks by order and the number of allocated blocks.
+func (b *Buddy) Stats() (stats BuddyStats) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stats.PoolSize = len(b.memoryPool)
+	stats.MinOrder = b.minOrder
+	stats.FreeBlocks = make([]int, bits.Len(uint(b.size)))
+	stats.LargestOrder = bits.Len(uint(b.longests[0])) - 1
+	b.walk(func(index, nodeSize int, free bool) {
+		if free {
+			stats.FreeSize += nodeSize << b.minOrder
+			stats.FreeBlocks[bits.Len(uint(nodeSize))-1]++
+		} else {
+			stats.Allocated++
+		}
+	})
+	return
+}
+
+// Walk calls fn with the byte offset in the pool and the size of every
+// allocated block, in address order. fn must not call into b.
+func (b *Buddy) Walk(fn func(offset, size int)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.walk(func(index, nodeSize int, free bool) {
+		if !free {
+			fn(((index+1)*nodeSize-b.size)<<b.minOrder, nodeSize<<b.minOrder)
+		}
+	})
+}
+
+// walk visits the free and allocated blocks in address order. A node whose
+// longest equals its size is free; a zero node is allocated as a whole when
+// its children are untouched, otherwise both its halves are in use.
+func (b *Buddy) walk(fn func(index, nodeSize int, free bool)) {
+	if b.size == 0 {
+		return
+	}
+	var visit func(index, nodeSize int)
+	visit = func(index, nodeSize int) {
+		switch longest := b.longests[index]; {
+		case longest == nodeSize:
+			fn(index, nodeSize, true)
+		case longest == 0 && (nodeSize == 1 || b.longests[leftChild(index)] != 0):
+			fn(index, nodeSize, false)
+		default:
+			visit(leftChild(index), nodeSize/2)
+			visit(rightChild(index), nodeSize/2)
+		}
+	}
+	visit(0, b.size)
+}
+
 // helpers
 func isPowerOf2(size int) bool {
 	return size&(size-1) == 0
@@ -0,0 +1,290 @@
+//go:build !disable_rm
+
+package gomem
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// BuddyExhaustionPolicy tells a BuddyArena what to do when no buddy can fit
+// a child and MaxBuddies are already in use.
+type BuddyExhaustionPolicy int
+
+const (
+	// BuddyFallbackHeap creates the child outside the arena, with
+	// createMemoryAllocator
+	BuddyFallbackHeap BuddyExhaustionPolicy = iota
+	// BuddyBlock waits until a child of the arena is recycled. A
+	// ScalableMemoryAllocator growing into the arena unlocks itself while it
+	// waits, so other goroutines can still free into it, but a goroutine
+	// must not wait for children that only it would recycle. If it is
+	// recycled meanwhile, its Malloc fails with ErrRecycled.
+	BuddyBlock
+	// BuddyReturnError fails with ErrBuddyExhausted
+	BuddyReturnError
+)
+
+var (
+	// ErrBuddyExhausted is returned by BuddyArena.Get when the arena is full
+	// and its policy is BuddyReturnError, or when the child is larger than a
+	// buddy and the policy is not BuddyFallbackHeap.
+	ErrBuddyExhausted = errors.New("buddy: arena exhausted")
+	// ErrBuddyArenaInUse is returned by BuddyArena.Close while children taken
+	// from the arena have not been recycled.
+	ErrBuddyArenaInUse = errors.New("buddy: arena has children in use")
+)
+
+type (
+	// BuddyArenaConfig configures a BuddyArena. Zero fields take the
+	// defaults of the enable_buddy build.
+	BuddyArenaConfig struct {
+		TotalSize   int    // bytes per buddy, BuddySize when 0
+		MinOrder    int    // log2 of the buddy unit, MinPowerOf2 when 0
+		Pool        []byte // backs a single buddy instead of anonymous mappings
+		MaxBuddies  int    // 0 for no limit, always 1 with Pool
+		OnExhausted BuddyExhaustionPolicy
+	}
+	// BuddyArenaStats is a snapshot of a BuddyArena.
+	BuddyArenaStats struct {
+		Buddies        int   // buddies currently held
+		Children       int   // children taken from the buddies and not recycled
+		BuddiesCreated int64 // buddies created over the arena's life
+		BuddiesClosed  int64 // buddies released by Trim or Close
+		HeapFallbacks  int64 // children created outside the arena
+		Errors         int64 // Get calls that returned ErrBuddyExhausted
+		Waits          int64 // Get calls that blocked for a recycled child
+		Pools          []BuddyStats
+	}
+	buddySlot struct {
+		buddy    *FreeListBuddy
+		children int
+	}
+	// BuddyArena owns the buddies children are carved from under
+	// enable_buddy. Buddies are created on demand up to MaxBuddies and live
+	// until Trim or Close releases them.
+	BuddyArena struct {
+		mu      sync.Mutex
+		cond    sync.Cond
+		config  BuddyArenaConfig
+		slots   []*buddySlot
+		stats   BuddyArenaStats
+		waiting int
+	}
+)
+
+var defaultBuddyArena atomic.Pointer[BuddyArena]
+
+func init() {
+	arena, _ := NewBuddyArena(BuddyArenaConfig{})
+	defaultBuddyArena.Store(arena)
+}
+
+// DefaultBuddyArena returns the arena GetMemoryAllocator uses under
+// enable_buddy.
+func DefaultBuddyArena() *BuddyArena {
+	return defaultBuddyArena.Load()
+}
+
+// SetDefaultBuddyArena replaces the arena GetMemoryAllocator uses under
+// enable_buddy. Children already taken go back to the arena they came from.
+func SetDefaultBuddyArena(arena *BuddyArena) {
+	defaultBuddyArena.Store(arena)
+}
+
+// SetBuddyConfig replaces the default arena with one of totalSize-byte
+// buddies in units of 1<<minOrder bytes. With a nil pool every buddy maps its
+// own memory; otherwise pool backs a single buddy.
+func SetBuddyConfig(totalSize, minOrder int, pool []byte) error {
+	arena, err := NewBuddyArena(BuddyArenaConfig{TotalSize: totalSize, MinOrder: minOrder, Pool: pool})
+	if err == nil {
+		SetDefaultBuddyArena(arena)
+	}
+	return err
+}
+
+// NewBuddyArena creates an arena. Only a caller-provided pool is turned into
+// a buddy at once; other buddies are mapped by Get when needed.
+func NewBuddyArena(config BuddyArenaConfig) (*BuddyArena, error) {
+	if config.TotalSize == 0 {
+		config.TotalSize = BuddySize
+	}
+	if config.MinOrder == 0 {
+		config.MinOrder = MinPowerOf2
+	}
+	if config.MaxBuddies < 0 {
+		return nil, InValidParameterErr
+	}
+	if err := checkBuddyConfig(config.TotalSize, config.MinOrder); err != nil {
+		return nil, err
+	}
+	arena := &BuddyArena{config: config}
+	arena.cond.L = &arena.mu
+	if config.Pool != nil {
+		buddy, err := NewFreeListBuddy(config.TotalSize, config.MinOrder, config.Pool)
+		if err != nil {
+			return nil, err
+		}
+		arena.config.MaxBuddies = 1
+		arena.slots = []*buddySlot{{buddy: buddy}}
+		arena.stats.BuddiesCreated = 1
+	}
+	return arena, nil
+}
+
+// Get returns a child of size bytes carved from one of the buddies, trying
+// them in creation order and creating a new one when none fits. When the
+// arena is exhausted it acts on the configured BuddyExhaustionPolicy.
+func (a *BuddyArena) Get(size int) (*MemoryAllocator, error) {
+	return a.get(size, nil)
+}
+
+// get is Get for a caller holding held, which is unlocked while BuddyBlock
+// waits and locked again before get returns
+func (a *BuddyArena) get(size int, held sync.Locker) (*MemoryAllocator, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if size > a.config.TotalSize {
+		return a.exhausted(size)
+	}
+	unit := 1 << a.config.MinOrder
+	units := (size + unit - 1) >> a.config.MinOrder
+	for {
+		for _, slot := range a.slots {
+			if offset, err := slot.buddy.Alloc(units); err == nil {
+				return a.carve(slot, size, offset), nil
+			}
+		}
+		if a.config.MaxBuddies == 0 || len(a.slots) < a.config.MaxBuddies {
+			buddy, err := NewFreeListBuddy(a.config.TotalSize, a.config.MinOrder, nil)
+			if err != nil {
+				return nil, err
+			}
+			slot := &buddySlot{buddy: buddy}
+			a.slots = append(a.slots, slot)
+			a.stats.BuddiesCreated++
+			offset, _ := buddy.Alloc(units)
+			return a.carve(slot, size, offset), nil
+		}
+		if a.config.OnExhausted != BuddyBlock {
+			return a.exhausted(size)
+		}
+		a.stats.Waits++
+		a.waiting++
+		if held != nil {
+			// still under a.mu, so no put can be missed before Wait
+			held.Unlock()
+		}
+		a.cond.Wait()
+		a.waiting--
+		if held != nil {
+			// held is taken before a.mu everywhere else
+			a.mu.Unlock()
+			held.Lock()
+			a.mu.Lock()
+		}
+	}
+}
+
+// exhausted applies the policy to a child that no buddy can hold
+func (a *BuddyArena) exhausted(size int) (*MemoryAllocator, error) {
+	if a.config.OnExhausted == BuddyFallbackHeap {
+		a.stats.HeapFallbacks++
+		return createMemoryAllocator(size), nil
+	}
+	a.stats.Errors++
+	return nil, ErrBuddyExhausted
+}
+
+func (a *BuddyArena) carve(slot *buddySlot, size, offset int) *MemoryAllocator {
+	slot.children++
+	a.stats.Children++
+	buddy := slot.buddy
+	start := offset << buddy.minOrder
+	ret := &MemoryAllocator{
+		allocator: NewFreeSpaceIndex(DefaultStrategy, size),
+		Size:      size,
+		memory:    buddy.memoryPool[start : start+size],
+		start:     buddy.poolStart + int64(start),
+		recycle: func() {
+			a.put(slot, offset)
+		},
+	}
+	ret.allocator.Init(size)
+	return ret
+}
+
+func (a *BuddyArena) put(slot *buddySlot, offset int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	slot.buddy.Free(offset)
+	slot.children--
+	a.stats.Children--
+	if a.waiting > 0 {
+		a.cond.Broadcast()
+	}
+}
+
+// Trim releases the buddies no child is carved from, except a
+// caller-provided pool, and returns how many it released.
+func (a *BuddyArena) Trim() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.trim()
+}
+
+func (a *BuddyArena) trim() (released int) {
+	if a.config.Pool != nil {
+		return 0
+	}
+	kept := a.slots[:0]
+	for _, slot := range a.slots {
+		if slot.children == 0 {
+			slot.buddy.Close()
+			released++
+		} else {
+			kept = append(kept, slot)
+		}
+	}
+	clear(a.slots[len(kept):])
+	a.slots = kept
+	a.stats.BuddiesClosed += int64(released)
+	return
+}
+
+// Close releases every buddy of the arena. It returns ErrBuddyArenaInUse,
+// and releases nothing, while children taken from it are not recycled. The
+// arena maps new buddies if it is used again.
+func (a *BuddyArena) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stats.Children > 0 {
+		return ErrBuddyArenaInUse
+	}
+	a.trim()
+	return nil
+}
+
+// Stats returns the arena counters and the statistics of every buddy, in
+// creation order.
+func (a *BuddyArena) Stats() (stats BuddyArenaStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats = a.stats
+	stats.Buddies = len(a.slots)
+	stats.Pools = make([]BuddyStats, len(a.slots))
+	for i, slot := range a.slots {
+		stats.Pools[i] = slot.buddy.Stats()
+	}
+	return
+}
+
+// buddyFallbacks returns the heap fallbacks of the default arena, without
+// walking its buddies like Stats
+func buddyFallbacks() int64 {
+	a := DefaultBuddyArena()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stats.HeapFallbacks
+}
@@ -0,0 +1,194 @@
+package gomem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuddyArena(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy BuddyExhaustionPolicy
+	}{{"heap", BuddyFallbackHeap}, {"error", BuddyReturnError}, {"block", BuddyBlock}} {
+		t.Run(tc.name, func(t *testing.T) {
+			arena, err := NewBuddyArena(BuddyArenaConfig{TotalSize: 1 << 16, MinOrder: 12, MaxBuddies: 2, OnExhausted: tc.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var children []*MemoryAllocator
+			for range 4 {
+				child, err := arena.Get(1 << 15)
+				if err != nil {
+					t.Fatal(err)
+				}
+				children = append(children, child)
+			}
+			if stats := arena.Stats(); stats.Buddies != 2 || stats.BuddiesCreated != 2 || stats.Children != 4 || stats.Pools[1].FreeSize != 0 {
+				t.Fatalf("stats %+v", stats)
+			}
+			switch tc.policy {
+			case BuddyFallbackHeap:
+				child, err := arena.Get(1 << 12)
+				if err != nil || child == nil {
+					t.Fatal(err)
+				}
+				child.Recycle()
+				if stats := arena.Stats(); stats.HeapFallbacks != 1 || stats.Buddies != 2 {
+					t.Fatalf("stats %+v", stats)
+				}
+			case BuddyReturnError:
+				if _, err := arena.Get(1 << 12); err != ErrBuddyExhausted {
+					t.Fatalf("get from a full arena: %v", err)
+				}
+				if _, err := arena.Get(1 << 17); err != ErrBuddyExhausted {
+					t.Fatalf("get larger than a buddy: %v", err)
+				}
+				if stats := arena.Stats(); stats.Errors != 2 {
+					t.Fatalf("stats %+v", stats)
+				}
+			case BuddyBlock:
+				got := make(chan *MemoryAllocator)
+				go func() {
+					child, _ := arena.Get(1 << 12)
+					got <- child
+				}()
+				select {
+				case <-got:
+					t.Fatal("get from a full arena did not block")
+				case <-time.After(10 * time.Millisecond):
+				}
+				children[1].Recycle()
+				children[1] = <-got
+				if &children[1].memory[0] != &arena.slots[0].buddy.memoryPool[1<<15] {
+					t.Fatal("blocked get did not take the recycled space")
+				}
+				if stats := arena.Stats(); stats.Waits == 0 {
+					t.Fatalf("stats %+v", stats)
+				}
+			}
+			if err := arena.Close(); err != ErrBuddyArenaInUse {
+				t.Fatalf("close with children in use: %v", err)
+			}
+			children[2].Recycle()
+			children[3].Recycle()
+			if released := arena.Trim(); released != 1 {
+				t.Fatalf("trim released %d buddies", released)
+			}
+			children[0].Recycle()
+			children[1].Recycle()
+			if err := arena.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if stats := arena.Stats(); stats.Buddies != 0 || stats.BuddiesClosed != 2 || stats.Children != 0 {
+				t.Fatalf("stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSetBuddyConfig(t *testing.T) {
+	defer SetDefaultBuddyArena(DefaultBuddyArena())
+	pool := make([]byte, 1<<20)
+	if err := SetBuddyConfig(1<<20, 12, pool); err != nil {
+		t.Fatal(err)
+	}
+	child, err := DefaultBuddyArena().Get(1 << 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &child.memory[0] != &pool[0] {
+		t.Fatal("the default arena does not carve from the caller-provided pool")
+	}
+	child.Recycle()
+	if err := SetBuddyConfig(1<<20, 30, nil); err != InValidParameterErr {
+		t.Fatalf("invalid config: %v", err)
+	}
+}
+
+// TestBuddyBlockUnlocksAllocator checks that a ScalableMemoryAllocator
+// waiting for a full BuddyBlock arena can still free the child the arena
+// waits for
+func TestBuddyBlockUnlocksAllocator(t *testing.T) {
+	defer SetDefaultBuddyArena(DefaultBuddyArena())
+	arena, err := NewBuddyArena(BuddyArenaConfig{TotalSize: 1 << 16, MinOrder: 12, MaxBuddies: 1, OnExhausted: BuddyBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultBuddyArena(arena)
+	sma := NewScalableMemoryAllocator(1<<14, WithQuota(1<<16))
+	defer sma.Recycle()
+	if arena.Stats().Children == 0 {
+		t.Skip("children come from the buddy arena with enable_buddy only")
+	}
+	sma.Malloc(1 << 14)         // fills the first child, at 0
+	last := sma.Malloc(1 << 15) // a second child, at 32K
+	blocker, _ := arena.Get(1 << 14)
+	defer blocker.Recycle()
+	// the arena is full: the third child waits for the second to be dropped
+	got := make(chan []byte)
+	go func() {
+		got <- sma.Malloc(1 << 14)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	freed := make(chan bool)
+	go func() {
+		freed <- sma.Free(last)
+	}()
+	select {
+	case ok := <-freed:
+		if !ok {
+			t.Fatal("free failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Free blocked behind the waiting Malloc")
+	}
+	select {
+	case mem := <-got:
+		if len(mem) != 1<<14 {
+			t.Fatalf("got %d bytes", len(mem))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Malloc did not take the dropped child's space")
+	}
+	if stats := arena.Stats(); stats.Waits == 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+// TestBuddyBlockRecycledAllocator checks that a Malloc waiting for a full
+// BuddyBlock arena gives its child back when the allocator is recycled
+// meanwhile
+func TestBuddyBlockRecycledAllocator(t *testing.T) {
+	defer SetDefaultBuddyArena(DefaultBuddyArena())
+	arena, err := NewBuddyArena(BuddyArenaConfig{TotalSize: 1 << 16, MinOrder: 12, MaxBuddies: 1, OnExhausted: BuddyBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultBuddyArena(arena)
+	sma := NewScalableMemoryAllocator(1<<15, WithQuota(1<<16))
+	if arena.Stats().Children == 0 {
+		t.Skip("children come from the buddy arena with enable_buddy only")
+	}
+	sma.Malloc(1 << 15) // fills the first child, at 0
+	blocker, _ := arena.Get(1 << 15)
+	defer blocker.Recycle()
+	// the arena is full: the second child waits for the first to be dropped
+	failed := make(chan error)
+	go func() {
+		_, err := sma.TryMalloc(1)
+		failed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sma.Recycle()
+	select {
+	case err := <-failed:
+		if err != ErrRecycled {
+			t.Fatalf("TryMalloc on a recycled allocator: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Malloc did not wake up after Recycle")
+	}
+	if stats := arena.Stats(); stats.Children != 1 || stats.Waits == 0 {
+		t.Fatalf("the child of a recycled allocator was kept: %+v", stats)
+	}
+}
@@ -145,3 +145,109 @@ func BenchmarkBuddyNonPowerOf2(b *testing.B) {
 		}
 	})
 }
+
+// buddyAllocator is the Alloc/Free contract shared by Buddy and FreeListBuddy
+type buddyAllocator interface {
+	Alloc(size int) (int, error)
+	Free(offset int) error
+}
+
+// buddyImplementations returns a fresh BuddySize pool for each implementation
+func buddyImplementations(b *testing.B) []struct {
+	name  string
+	buddy func() buddyAllocator
+} {
+	return []struct {
+		name  string
+		buddy func() buddyAllocator
+	}{
+		{"Tree", func() buddyAllocator { return NewBuddy() }},
+		{"FreeList", func() buddyAllocator {
+			buddy, err := NewFreeListBuddy(BuddySize, MinPowerOf2, make([]byte, BuddySize))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return buddy
+		}},
+	}
+}
+
+// BenchmarkBuddyImplementationsAlloc compares one alloc/free pair of a
+// child-sized block (16 KiB)
+func BenchmarkBuddyImplementationsAlloc(b *testing.B) {
+	for _, impl := range buddyImplementations(b) {
+		b.Run(impl.name, func(b *testing.B) {
+			buddy := impl.buddy()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				offset, err := buddy.Alloc(16)
+				if err != nil {
+					b.Fatal("Failed to allocate memory:", err)
+				}
+				if err = buddy.Free(offset); err != nil {
+					b.Fatal("Failed to free memory:", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBuddyImplementationsFragmented allocates and frees a block of an
+// order that has free blocks, in a pool where every other block of that order
+// is held
+func BenchmarkBuddyImplementationsFragmented(b *testing.B) {
+	for _, impl := range buddyImplementations(b) {
+		b.Run(impl.name, func(b *testing.B) {
+			buddy := impl.buddy()
+			offsets := make([]int, BuddySize>>MinPowerOf2>>1)
+			for i := range offsets {
+				offset, err := buddy.Alloc(2)
+				if err != nil {
+					b.Fatal("Failed to allocate memory:", err)
+				}
+				offsets[i] = offset
+			}
+			for i := 0; i < len(offsets); i += 2 {
+				if err := buddy.Free(offsets[i]); err != nil {
+					b.Fatal("Failed to free memory:", err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				offset, err := buddy.Alloc(2)
+				if err != nil {
+					b.Fatal("Failed to allocate memory:", err)
+				}
+				if err = buddy.Free(offset); err != nil {
+					b.Fatal("Failed to free memory:", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBuddyImplementationsSequential allocates 100 child-sized blocks,
+// then frees them all
+func BenchmarkBuddyImplementationsSequential(b *testing.B) {
+	for _, impl := range buddyImplementations(b) {
+		b.Run(impl.name, func(b *testing.B) {
+			buddy := impl.buddy()
+			offsets := make([]int, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range offsets {
+					offset, err := buddy.Alloc(16)
+					if err != nil {
+						b.Fatal("Failed to allocate memory:", err)
+					}
+					offsets[j] = offset
+				}
+				for _, offset := range offsets {
+					if err := buddy.Free(offset); err != nil {
+						b.Fatal("Failed to free memory:", err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ func init() {
 		ret.recycle = func() {
 			pool0.Put(ret)
 		}
+		ret.resize = nil // pooled by size
 		return ret
 	}
 	pool1.New = func() any {
@@ -21,6 +22,7 @@ func init() {
 		ret.recycle = func() {
 			pool1.Put(ret)
 		}
+		ret.resize = nil
 		return ret
 	}
 	pool2.New = func() any {
@@ -28,6 +30,7 @@ func init() {
 		ret.recycle = func() {
 			pool2.Put(ret)
 		}
+		ret.resize = nil
 		return ret
 	}
 }
@@ -48,3 +51,8 @@ func GetMemoryAllocator(size int) (ret *MemoryAllocator) {
 	}
 	return
 }
+
+// getMemoryAllocator is GetMemoryAllocator, which never blocks without buddies
+func getMemoryAllocator(size int, held sync.Locker) *MemoryAllocator {
+	return GetMemoryAllocator(size)
+}
@@ -2,34 +2,17 @@
 
 package gomem
 
-func createMemoryAllocatorFromBuddy(size int, buddy *Buddy, offset int) *MemoryAllocator {
-	ret := &MemoryAllocator{
-		allocator: NewAllocator(size),
-		Size:      size,
-		memory:    buddy.memoryPool[offset : offset+size],
-		start:     buddy.poolStart + int64(offset),
-		recycle: func() {
-			buddy.Free(offset >> MinPowerOf2)
-		},
-	}
-	ret.allocator.Init(size)
-	return ret
-}
+import "sync"
 
+// GetMemoryAllocator carves the child from DefaultBuddyArena. It returns nil
+// when the arena is exhausted and configured with BuddyReturnError.
 func GetMemoryAllocator(size int) (ret *MemoryAllocator) {
-	if size < BuddySize {
-		requiredSize := size >> MinPowerOf2
-		// Loop to get an available buddy from the pool
-		for {
-			buddy := GetBuddy()
-			defer PutBuddy(buddy)
-			offset, err := buddy.Alloc(requiredSize)
-			if err == nil {
-				// Allocation successful, use this buddy
-				return createMemoryAllocatorFromBuddy(size, buddy, offset<<MinPowerOf2)
-			}
-		}
-	}
-	// No buddy available or size too large, use system memory
-	return createMemoryAllocator(size)
+	return getMemoryAllocator(size, nil)
+}
+
+// getMemoryAllocator is GetMemoryAllocator for a caller holding held, which
+// is unlocked while the arena blocks
+func getMemoryAllocator(size int, held sync.Locker) (ret *MemoryAllocator) {
+	ret, _ = DefaultBuddyArena().get(size, held)
+	return
 }
@@ -0,0 +1,298 @@
+package gomem
+
+import (
+	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// FreeListBuddy is a buddy allocator with the Alloc/Free contract of Buddy
+// that keeps a free list per order instead of the longests tree. Alloc pops
+// the smallest non-empty order at or above the request, found with one bit
+// scan, so the common order costs O(1); only splitting and merging walk the
+// orders. A bitmap per order marks the free blocks, which is how Free finds
+// a free buddy to merge with; a block alone in its list is found through the
+// head instead, so a split chain on an empty pool and its merge back are
+// O(order) over the small per-order arrays. BuddyArena carves children from
+// FreeListBuddy pools.
+type FreeListBuddy struct {
+	size       int // number of 1<<minOrder units in the pool
+	minOrder   int
+	maxOrder   int
+	heads      [32]int32   // first free block of each order, -1 if none
+	counts     [32]int32   // length of the free list of each order
+	links      []buddyLink // free list links by block offset, unused while a list holds one block
+	free       []uint64    // bit freeBase[o]*64+offset>>o set when that block of order o is free and not alone
+	freeBase   [32]int     // first word of the bitmap of each order in free
+	nonEmpty   uint64      // bit o set when heads[o] != -1
+	allocated  []uint8     // order+1 of the allocated block at an offset, 0 if none
+	memoryPool []byte
+	poolStart  int64
+	release    func() error // unmaps a pool created by mmap
+	lock       sync.Mutex
+}
+
+type buddyLink struct{ next, prev int32 }
+
+// NewFreeListBuddy creates a free list buddy managing totalSize bytes in
+// units of 1<<minOrder bytes, with the same parameters as
+// NewBuddyWithConfig. Release a mmap pool with Close.
+func NewFreeListBuddy(totalSize, minOrder int, pool []byte) (*FreeListBuddy, error) {
+	if err := checkBuddyConfig(totalSize, minOrder); err != nil || totalSize>>minOrder > math.MaxInt32 {
+		return nil, InValidParameterErr
+	}
+	var release func() error
+	if pool != nil {
+		if len(pool) < totalSize {
+			return nil, InValidParameterErr
+		}
+		pool = pool[:totalSize:totalSize]
+	} else {
+		var err error
+		if pool, release, err = mmapPool(totalSize); err != nil {
+			return nil, err
+		}
+	}
+	size := totalSize >> minOrder
+	maxOrder := bits.Len(uint(size)) - 1
+	ret := &FreeListBuddy{
+		size:       size,
+		minOrder:   minOrder,
+		maxOrder:   maxOrder,
+		links:      make([]buddyLink, size),
+		allocated:  make([]uint8, size),
+		memoryPool: pool,
+		poolStart:  int64(uintptr(unsafe.Pointer(&pool[0]))),
+		release:    release,
+	}
+	// the bitmaps share one array, top order first: allocated one by one, the
+	// large ones would start on page boundaries and their first words, which
+	// every split from offset 0 touches, would compete for one cache set
+	words := 0
+	for o := maxOrder; o >= 0; o-- {
+		ret.heads[o] = -1
+		ret.freeBase[o] = words
+		words += (size>>o + 63) / 64
+	}
+	ret.free = make([]uint64, words)
+	ret.push(0, maxOrder)
+	if release != nil {
+		runtime.SetFinalizer(ret, (*FreeListBuddy).Close)
+	}
+	return ret, nil
+}
+
+// MinOrder returns the log2 of the unit Alloc and Free count in.
+func (b *FreeListBuddy) MinOrder() int {
+	return b.minOrder
+}
+
+// PoolSize returns the number of bytes managed by the buddy.
+func (b *FreeListBuddy) PoolSize() int {
+	return len(b.memoryPool)
+}
+
+// Alloc finds an unused block of at least size units and returns its offset
+// in units, like Buddy.Alloc.
+func (b *FreeListBuddy) Alloc(size int) (offset int, err error) {
+	if size <= 0 {
+		err = InValidParameterErr
+		return
+	}
+	order := bits.Len(uint(size - 1))
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if order > b.maxOrder || b.nonEmpty>>order == 0 {
+		err = NotFoundErr
+		return
+	}
+	o := order + bits.TrailingZeros64(b.nonEmpty>>order)
+	offset = int(b.heads[o])
+	b.remove(offset, o)
+	// return the upper halves to the lower orders, which are empty since o is
+	// the smallest non-empty order: each half becomes a list of its own
+	b.nonEmpty |= 1<<o - 1<<order
+	for o > order {
+		o--
+		b.heads[o] = int32(offset + 1<<o)
+		b.counts[o] = 1
+	}
+	b.allocated[offset] = uint8(order + 1)
+	return
+}
+
+// Free releases the block allocated at offset and merges it with its free
+// buddies, like Buddy.Free.
+func (b *FreeListBuddy) Free(offset int) error {
+	if offset < 0 || offset >= b.size {
+		return InValidParameterErr
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.allocated[offset] == 0 {
+		return NotFoundErr
+	}
+	o := int(b.allocated[offset]) - 1
+	b.allocated[offset] = 0
+	for ; o < b.maxOrder; o++ {
+		buddy := offset ^ 1<<o
+		if b.counts[o] == 1 {
+			// the common case of a buddy alone in its list, inlined
+			if int(b.heads[o]) != buddy {
+				break
+			}
+			b.heads[o], b.counts[o] = -1, 0
+			b.nonEmpty &^= 1 << o
+		} else if b.isFree(buddy, o) {
+			b.remove(buddy, o)
+		} else {
+			break
+		}
+		offset = min(offset, buddy)
+	}
+	b.push(offset, o)
+	return nil
+}
+
+// Stats returns the free blocks by order and the number of allocated blocks.
+func (b *FreeListBuddy) Stats() (stats BuddyStats) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stats.PoolSize = len(b.memoryPool)
+	stats.MinOrder = b.minOrder
+	stats.FreeBlocks = make([]int, b.maxOrder+1)
+	stats.LargestOrder = bits.Len64(b.nonEmpty) - 1
+	b.walk(func(offset, order int, free bool) {
+		if free {
+			stats.FreeSize += 1 << (order + b.minOrder)
+			stats.FreeBlocks[order]++
+		} else {
+			stats.Allocated++
+		}
+	})
+	return
+}
+
+// Walk calls fn with the byte offset in the pool and the size of every
+// allocated block, in address order. fn must not call into b.
+func (b *FreeListBuddy) Walk(fn func(offset, size int)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.walk(func(offset, order int, free bool) {
+		if !free {
+			fn(offset<<b.minOrder, 1<<(order+b.minOrder))
+		}
+	})
+}
+
+// walk visits the free and allocated blocks in address order, skipping over
+// each block by its order
+func (b *FreeListBuddy) walk(fn func(offset, order int, free bool)) {
+	for offset := 0; offset < b.size; {
+		order := int(b.allocated[offset]) - 1
+		free := order < 0
+		if free {
+			// the free block starting here is of the largest order it is
+			// aligned to and marked free in
+			order = min(bits.TrailingZeros(uint(offset)), b.maxOrder)
+			for !b.isFree(offset, order) {
+				order--
+			}
+		}
+		fn(offset, order, free)
+		offset += 1 << order
+	}
+}
+
+// Close unmaps a pool created by NewFreeListBuddy. Memory handed out by the
+// buddy must not be used afterwards. It does nothing for other pools.
+func (b *FreeListBuddy) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.release == nil {
+		return nil
+	}
+	runtime.SetFinalizer(b, nil)
+	release := b.release
+	b.release = nil
+	b.size, b.maxOrder, b.nonEmpty = 0, -1, 0
+	b.memoryPool = nil
+	return release()
+}
+
+// isFree reports whether the block at offset is free in its order. A block
+// alone in its list is its head and is not marked in the bitmap
+func (b *FreeListBuddy) isFree(offset, order int) bool {
+	switch b.counts[order] {
+	case 0:
+		return false
+	case 1:
+		return int(b.heads[order]) == offset
+	}
+	i := uint(offset >> order)
+	return b.free[b.freeBase[order]+int(i/64)]&(1<<(i%64)) != 0
+}
+
+// mark sets or clears the bit of the block at offset in its order's bitmap
+func (b *FreeListBuddy) mark(offset, order int, free bool) {
+	i := uint(offset >> order)
+	if free {
+		b.free[b.freeBase[order]+int(i/64)] |= 1 << (i % 64)
+	} else {
+		b.free[b.freeBase[order]+int(i/64)] &^= 1 << (i % 64)
+	}
+}
+
+// push adds the free block at offset to the head of its order's list. A
+// block alone in its list has neither links nor a bit in the bitmap, so that
+// splitting a large block, where every half lands in an empty list, and
+// merging it back only touch the small per-order arrays
+func (b *FreeListBuddy) push(offset, order int) {
+	switch head := b.heads[order]; b.counts[order] {
+	case 0:
+		b.nonEmpty |= 1 << order
+	case 1:
+		b.mark(int(head), order, true)
+		b.mark(offset, order, true)
+		b.links[head] = buddyLink{-1, int32(offset)}
+		b.links[offset] = buddyLink{head, -1}
+	default:
+		b.mark(offset, order, true)
+		b.links[head].prev = int32(offset)
+		b.links[offset] = buddyLink{head, -1}
+	}
+	b.heads[order] = int32(offset)
+	b.counts[order]++
+}
+
+// remove unlinks the free block at offset from its order's list
+func (b *FreeListBuddy) remove(offset, order int) {
+	switch b.counts[order] {
+	case 1:
+		b.heads[order] = -1
+		b.nonEmpty &^= 1 << order
+	case 2:
+		// the other block is left alone, its links and bit are dropped
+		other := b.links[offset].next
+		if other == -1 {
+			other = b.links[offset].prev
+		}
+		b.mark(offset, order, false)
+		b.mark(int(other), order, false)
+		b.heads[order] = other
+	default:
+		b.mark(offset, order, false)
+		next, prev := b.links[offset].next, b.links[offset].prev
+		if prev != -1 {
+			b.links[prev].next = next
+		} else {
+			b.heads[order] = next
+		}
+		if next != -1 {
+			b.links[next].prev = prev
+		}
+	}
+	b.counts[order]--
+}
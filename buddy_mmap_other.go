@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package gomem
+
+// mmapPool falls back to the Go heap where anonymous mappings are not wired up
+func mmapPool(size int) (pool []byte, release func() error, err error) {
+	return make([]byte, size), func() error { return nil }, nil
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package gomem
+
+import "syscall"
+
+// mmapPool maps size bytes of anonymous memory for a buddy pool
+func mmapPool(size int) (pool []byte, release func() error, err error) {
+	pool, err = syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, func() error { return syscall.Munmap(pool) }, nil
+}
@@ -0,0 +1,171 @@
+package gomem
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestBuddyWithConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pool []byte
+	}{{"mmap", nil}, {"caller", make([]byte, 1<<20)}} {
+		t.Run(tc.name, func(t *testing.T) {
+			buddy, err := NewBuddyWithConfig(1<<16, 12, tc.pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer buddy.Close()
+			if buddy.PoolSize() != 1<<16 || buddy.MinOrder() != 12 {
+				t.Fatalf("pool size %d, min order %d", buddy.PoolSize(), buddy.MinOrder())
+			}
+			var offsets []int
+			for range 16 {
+				offset, err := buddy.Alloc(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				buddy.memoryPool[offset<<12] = byte(offset)
+				offsets = append(offsets, offset)
+			}
+			if _, err := buddy.Alloc(1); err != NotFoundErr {
+				t.Fatalf("alloc from a full buddy: %v", err)
+			}
+			for _, offset := range offsets {
+				if err := buddy.Free(offset); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if offset, err := buddy.Alloc(16); err != nil || offset != 0 {
+				t.Fatalf("alloc the whole pool: %d %v", offset, err)
+			}
+		})
+	}
+	for _, tc := range []struct{ totalSize, minOrder int }{{3 << 12, 12}, {1 << 11, 12}, {1 << 16, -1}} {
+		if _, err := NewBuddyWithConfig(tc.totalSize, tc.minOrder, nil); err != InValidParameterErr {
+			t.Errorf("NewBuddyWithConfig(%d, %d): %v", tc.totalSize, tc.minOrder, err)
+		}
+	}
+	if _, err := NewBuddyWithConfig(1<<16, 12, make([]byte, 1<<15)); err != InValidParameterErr {
+		t.Errorf("short pool: %v", err)
+	}
+}
+
+func TestFreeListBuddy(t *testing.T) {
+	buddy, err := NewFreeListBuddy(1<<20, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buddy.Close()
+	// owner of every unit, to catch overlapping blocks
+	owner := make([]int, 1<<10)
+	live := map[int]int{} // offset -> units
+	rng := rand.New(rand.NewPCG(1, 2))
+	for step := 1; step <= 20000; step++ {
+		if len(live) > 0 && rng.IntN(2) == 0 {
+			for offset, units := range live {
+				if err := buddy.Free(offset); err != nil {
+					t.Fatalf("step %d: free %d: %v", step, offset, err)
+				}
+				clear(owner[offset : offset+units])
+				delete(live, offset)
+				break
+			}
+			continue
+		}
+		size := 1 + rng.IntN(32)
+		offset, err := buddy.Alloc(size)
+		if err == NotFoundErr {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("step %d: alloc %d: %v", step, size, err)
+		}
+		units := fixSize(size)
+		if isPowerOf2(size) {
+			units = size
+		}
+		if offset%units != 0 {
+			t.Fatalf("step %d: offset %d not aligned to %d", step, offset, units)
+		}
+		for i := offset; i < offset+units; i++ {
+			if owner[i] != 0 {
+				t.Fatalf("step %d: block %d+%d overlaps allocation %d", step, offset, units, owner[i])
+			}
+			owner[i] = step
+		}
+		live[offset] = units
+		if step%500 == 0 {
+			// Stats walks the blocks through isFree, lone blocks included
+			inUse := 0
+			for _, units := range live {
+				inUse += units
+			}
+			if stats := buddy.Stats(); stats.FreeSize != 1<<20-inUse<<10 || stats.Allocated != len(live) {
+				t.Fatalf("step %d: stats %+v with %d units in %d blocks", step, stats, inUse, len(live))
+			}
+		}
+	}
+	for offset := range live {
+		if err := buddy.Free(offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := buddy.Free(0); err != NotFoundErr {
+		t.Fatalf("double free: %v", err)
+	}
+	if offset, err := buddy.Alloc(1 << 10); err != nil || offset != 0 {
+		t.Fatalf("free blocks did not merge back: %d %v", offset, err)
+	}
+	if _, err := buddy.Alloc(1); err != NotFoundErr {
+		t.Fatalf("alloc from a full buddy: %v", err)
+	}
+}
+
+func TestBuddyStats(t *testing.T) {
+	type block struct{ offset, size int }
+	tree, err := NewBuddyWithConfig(1<<16, 10, make([]byte, 1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeList, err := NewFreeListBuddy(1<<16, 10, make([]byte, 1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buddy := range []interface {
+		Alloc(size int) (int, error)
+		Free(offset int) error
+		Stats() BuddyStats
+		Walk(fn func(offset, size int))
+	}{tree, freeList} {
+		if stats := buddy.Stats(); stats.FreeSize != 1<<16 || stats.LargestOrder != 6 || stats.FreeBlocks[6] != 1 {
+			t.Fatalf("%T empty stats %+v", buddy, stats)
+		}
+		for _, size := range []int{1, 4, 16} {
+			if _, err := buddy.Alloc(size); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats := buddy.Stats()
+		want := BuddyStats{PoolSize: 1 << 16, MinOrder: 10, FreeSize: 43 << 10, FreeBlocks: []int{1, 1, 0, 1, 0, 1, 0}, LargestOrder: 5, Allocated: 3}
+		if !reflect.DeepEqual(stats, want) {
+			t.Fatalf("%T stats %+v, want %+v", buddy, stats, want)
+		}
+		var blocks []block
+		buddy.Walk(func(offset, size int) {
+			blocks = append(blocks, block{offset, size})
+		})
+		if want := []block{{0, 1 << 10}, {4 << 10, 4 << 10}, {16 << 10, 16 << 10}}; !reflect.DeepEqual(blocks, want) {
+			t.Fatalf("%T walk %v, want %v", buddy, blocks, want)
+		}
+		for _, size := range []int{32, 8, 2, 1} {
+			if _, err := buddy.Alloc(size); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stats := buddy.Stats(); stats.FreeSize != 0 || stats.LargestOrder != -1 || stats.Allocated != 7 {
+			t.Fatalf("%T full stats %+v", buddy, stats)
+		}
+	}
+}
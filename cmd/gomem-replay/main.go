@@ -0,0 +1,74 @@
+// Command gomem-replay replays an allocation trace recorded by a
+// gomem.TraceRecorder against the free space index implementations and
+// reports where the replay diverges from the recording, the peak usage and
+// the fragmentation.
+//
+// Usage:
+//
+//	gomem-replay [-strategy treap|twotree|tlsf|all] [-n 10] trace-file
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/langhuihui/gomem"
+)
+
+func main() {
+	strategy := flag.String("strategy", "all", "implementation to replay against: treap, twotree, tlsf or all")
+	n := flag.Int("n", 10, "maximum number of divergences to print per strategy")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] trace-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var strategies []gomem.AllocatorStrategy
+	switch *strategy {
+	case "treap":
+		strategies = []gomem.AllocatorStrategy{gomem.StrategyTreap}
+	case "twotree":
+		strategies = []gomem.AllocatorStrategy{gomem.StrategyTwoTree}
+	case "tlsf":
+		strategies = []gomem.AllocatorStrategy{gomem.StrategyTLSF}
+	case "all":
+		strategies = []gomem.AllocatorStrategy{gomem.StrategyTreap, gomem.StrategyTwoTree, gomem.StrategyTLSF}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown strategy %q\n", *strategy)
+		os.Exit(2)
+	}
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	events, err := gomem.ReadTrace(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v (replaying the %d events read)\n", flag.Arg(0), err, len(events))
+	}
+	diverged := false
+	for _, s := range strategies {
+		report := gomem.ReplayTrace(events, s)
+		fmt.Printf("%s: %d events, %d divergences\n", report.Strategy, report.Events, len(report.Divergences))
+		fmt.Printf("  in use %d, peak %d, reserved %d\n", report.InUse, report.PeakInUse, report.Reserved)
+		fmt.Printf("  free blocks %d, largest %d, fragmentation %.4f\n", report.Free.FreeBlocks, report.Free.LargestFree, report.Free.Fragmentation())
+		for i, d := range report.Divergences {
+			if i == *n {
+				fmt.Printf("  ... %d more\n", len(report.Divergences)-i)
+				break
+			}
+			e := d.Event
+			fmt.Printf("  #%d %s child=%d size=%d arg=%d: recorded %d, replayed %d\n", d.Index, e.Op, e.Child, e.Size, e.Arg, e.Offset, d.Got)
+		}
+		diverged = diverged || len(report.Divergences) > 0
+	}
+	if diverged {
+		os.Exit(1)
+	}
+}
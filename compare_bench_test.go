@@ -6,7 +6,7 @@ import (
 
 // BenchmarkTwoTreeAllocator benchmarks the two-tree allocator
 func BenchmarkTwoTreeAllocator(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -34,7 +34,7 @@ func BenchmarkSingleTreeAllocator(b *testing.B) {
 
 // BenchmarkTwoTreeSmallAlloc benchmarks small allocations with two-tree
 func BenchmarkTwoTreeSmallAlloc(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -62,7 +62,7 @@ func BenchmarkSingleTreeSmallAlloc(b *testing.B) {
 
 // BenchmarkTwoTreeLargeAlloc benchmarks large allocations with two-tree
 func BenchmarkTwoTreeLargeAlloc(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -90,7 +90,7 @@ func BenchmarkSingleTreeLargeAlloc(b *testing.B) {
 
 // BenchmarkTwoTreeSequential benchmarks sequential allocation pattern with two-tree
 func BenchmarkTwoTreeSequential(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 	allocations := make([]int, 100)
 
 	b.ResetTimer()
@@ -136,7 +136,7 @@ func BenchmarkSingleTreeSequential(b *testing.B) {
 
 // BenchmarkTwoTreeRandom benchmarks random allocation pattern with two-tree
 func BenchmarkTwoTreeRandom(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 	sizes := []int{64, 128, 256, 512, 1024, 2048, 4096, 8192}
 
 	b.ResetTimer()
@@ -168,7 +168,7 @@ func BenchmarkSingleTreeRandom(b *testing.B) {
 
 // BenchmarkTwoTreeFind benchmarks find operation with two-tree
 func BenchmarkTwoTreeFind(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -188,7 +188,7 @@ func BenchmarkSingleTreeFind(b *testing.B) {
 
 // BenchmarkTwoTreeGetFreeSize benchmarks GetFreeSize with two-tree
 func BenchmarkTwoTreeGetFreeSize(b *testing.B) {
-	allocator := NewAllocator(1024 * 1024) // 1MB pool
+	allocator := NewTwoTreeAllocator(1024 * 1024) // 1MB pool
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -205,3 +205,129 @@ func BenchmarkSingleTreeGetFreeSize(b *testing.B) {
 		_ = allocator.GetFreeSize()
 	}
 }
+
+// BenchmarkTLSFAllocator benchmarks the TLSF allocator
+func BenchmarkTLSFAllocator(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := allocator.Allocate(1024)
+		if offset == -1 {
+			b.Fatal("Failed to allocate memory")
+		}
+		allocator.Free(offset, 1024)
+	}
+}
+
+// BenchmarkTLSFSmallAlloc benchmarks small allocations with TLSF
+func BenchmarkTLSFSmallAlloc(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := allocator.Allocate(64)
+		if offset == -1 {
+			b.Fatal("Failed to allocate memory")
+		}
+		allocator.Free(offset, 64)
+	}
+}
+
+// BenchmarkTLSFLargeAlloc benchmarks large allocations with TLSF
+func BenchmarkTLSFLargeAlloc(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := allocator.Allocate(8192)
+		if offset == -1 {
+			b.Fatal("Failed to allocate memory")
+		}
+		allocator.Free(offset, 8192)
+	}
+}
+
+// BenchmarkTLSFSequential benchmarks sequential allocation pattern with TLSF
+func BenchmarkTLSFSequential(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+	allocations := make([]int, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Allocate 100 blocks
+		for j := 0; j < 100; j++ {
+			offset := allocator.Allocate(1024)
+			if offset == -1 {
+				b.Fatal("Failed to allocate memory")
+			}
+			allocations[j] = offset
+		}
+
+		// Free all blocks
+		for j := 0; j < 100; j++ {
+			allocator.Free(allocations[j], 1024)
+		}
+	}
+}
+
+// BenchmarkTLSFRandom benchmarks random allocation pattern with TLSF
+func BenchmarkTLSFRandom(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+	sizes := []int{64, 128, 256, 512, 1024, 2048, 4096, 8192}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		size := sizes[i%len(sizes)]
+		offset := allocator.Allocate(size)
+		if offset == -1 {
+			b.Fatal("Failed to allocate memory")
+		}
+		allocator.Free(offset, size)
+	}
+}
+
+// BenchmarkTLSFFind benchmarks find operation with TLSF
+func BenchmarkTLSFFind(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = allocator.Find(1024)
+	}
+}
+
+// BenchmarkTLSFGetFreeSize benchmarks GetFreeSize with TLSF
+func BenchmarkTLSFGetFreeSize(b *testing.B) {
+	allocator := NewTLSFAllocator(1024 * 1024) // 1MB pool
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = allocator.GetFreeSize()
+	}
+}
+
+// BenchmarkFreeSpaceIndexFragmented frees and takes back blocks between
+// thousands of free blocks, where the neighbour lookups dominate
+func BenchmarkFreeSpaceIndexFragmented(b *testing.B) {
+	const size, block = 1024 * 1024, 64
+	for _, strategy := range []AllocatorStrategy{StrategyTreap, StrategyTwoTree, StrategyTLSF} {
+		b.Run(strategy.String(), func(b *testing.B) {
+			allocator := NewFreeSpaceIndex(strategy, size)
+			for allocator.Allocate(block) != -1 {
+			}
+			// every other block free
+			for offset := 0; offset < size; offset += 2 * block {
+				allocator.Free(offset, block)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				offset := (2*i + 1) * block % size
+				allocator.Free(offset, block)
+				if err := allocator.AllocateAt(offset, block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
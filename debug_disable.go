@@ -0,0 +1,7 @@
+//go:build !gomem_debug
+
+package gomem
+
+// debugValidate is off: build with the gomem_debug tag to validate the free
+// space indexes after every mutation.
+const debugValidate = false
@@ -0,0 +1,9 @@
+//go:build gomem_debug
+
+package gomem
+
+// debugValidate makes every free space index validate itself after each
+// Allocate, AllocateAligned, AllocateAt, Free, Extend, Grow and Shrink,
+// panicking with a dump of its free blocks as soon as it is corrupt. It is
+// very slow: use it in tests only.
+const debugValidate = true
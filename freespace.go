@@ -0,0 +1,158 @@
+package gomem
+
+import "errors"
+
+// AllocatorStrategy selects the data structure used to index the free space
+// of an arena. Both strategies are always compiled in, so a single binary can
+// mix them per allocator instance.
+type AllocatorStrategy int
+
+const (
+	// StrategyTreap keeps free blocks in a single treap ordered by offset and
+	// heap-ordered by size. It is the fastest for Allocate/Free.
+	StrategyTreap AllocatorStrategy = iota
+	// StrategyTwoTree keeps free blocks in two AVL trees, one ordered by size
+	// and one by offset. It is the fastest for Find (and therefore Borrow).
+	StrategyTwoTree
+	// StrategyTLSF keeps free blocks in two-level segregated fit lists.
+	// Allocate and Free take bounded time whatever the number of free blocks.
+	StrategyTLSF
+)
+
+// FitPolicy selects which free block an index hands out when several fit.
+type FitPolicy int
+
+const (
+	// FitDefault is the native search of each index: a best-ish fit along
+	// one path of the treap, the smallest fit in the two-tree allocator and a
+	// good fit in TLSF.
+	FitDefault FitPolicy = iota
+	// FitBest picks the smallest block that fits.
+	FitBest
+	// FitFirst picks the fitting block with the lowest offset, which keeps the
+	// end of the arena free.
+	FitFirst
+	// FitNext picks the first fitting block after the previous allocation,
+	// wrapping around to the start of the arena. Suited to streaming rings.
+	FitNext
+	// FitWorst picks the largest block.
+	FitWorst
+)
+
+type (
+	// Block is a free range [Start, End) of an arena.
+	Block struct {
+		Start int `yaml:"start"`
+		End   int `yaml:"end"`
+	}
+	// FreeSpaceIndex tracks the free ranges of a fixed-size arena by offset.
+	// Allocate returns -1 when no free block is large enough, Free returns
+	// ErrDoubleFree or ErrOverlap and changes nothing when the range is
+	// already free, in whole or in part.
+	FreeSpaceIndex interface {
+		Allocate(size int) (offset int)
+		AllocateAligned(size, align int) (offset int)
+		AllocateAt(offset, size int) error
+		Free(offset, size int) error
+		Extend(offset, oldSize, newSize int) bool
+		Find(size int) (offset int)
+		GetFreeSize() int
+		Stats() FreeSpaceStats
+		GetBlocks() []*Block
+		// IsEmpty reports whether nothing is allocated, i.e. the whole arena
+		// is a single free block.
+		IsEmpty() bool
+		// Grow enlarges the arena to newSize bytes, the new tail being free.
+		Grow(newSize int)
+		// Shrink reduces the arena to newSize bytes and reports whether it
+		// could, which requires [newSize, Size) to be free.
+		Shrink(newSize int) bool
+		Init(size int)
+		Recycle()
+		Strategy() AllocatorStrategy
+		// SetFitPolicy changes the policy of subsequent Allocate and Find calls.
+		// TLSFAllocator ignores it and always uses its good fit.
+		SetFitPolicy(policy FitPolicy)
+		// Validate checks the internal invariants of the index and returns an
+		// error wrapping ErrCorruptIndex when one is broken.
+		Validate() error
+	}
+)
+
+var (
+	// ErrDoubleFree is returned when freeing a range that is already free.
+	ErrDoubleFree = errors.New("freespace: double free")
+	// ErrOverlap is returned when freeing a range that overlaps a free block.
+	ErrOverlap = errors.New("freespace: free overlaps a free block")
+	// ErrAllocated is returned by AllocateAt when part of the range is
+	// already allocated.
+	ErrAllocated = errors.New("freespace: range is already allocated")
+	// ErrOutOfRange is returned by AllocateAt for a range outside the arena.
+	ErrOutOfRange = errors.New("freespace: range outside of arena")
+	// ErrNotOwned is returned when freeing memory that was not allocated by
+	// the allocator.
+	ErrNotOwned = errors.New("gomem: memory not owned by allocator")
+)
+
+var (
+	_ FreeSpaceIndex = (*Allocator)(nil)
+	_ FreeSpaceIndex = (*TwoTreeAllocator)(nil)
+	_ FreeSpaceIndex = (*TLSFAllocator)(nil)
+)
+
+// NewFreeSpaceIndex creates a free space index of the given strategy covering
+// an arena of size bytes.
+func NewFreeSpaceIndex(strategy AllocatorStrategy, size int) FreeSpaceIndex {
+	switch strategy {
+	case StrategyTwoTree:
+		return NewTwoTreeAllocator(size)
+	case StrategyTLSF:
+		return NewTLSFAllocator(size)
+	}
+	return NewAllocator(size)
+}
+
+func (s AllocatorStrategy) String() string {
+	switch s {
+	case StrategyTreap:
+		return "treap"
+	case StrategyTwoTree:
+		return "twotree"
+	case StrategyTLSF:
+		return "tlsf"
+	}
+	return "unknown"
+}
+
+func (p FitPolicy) String() string {
+	switch p {
+	case FitDefault:
+		return "default"
+	case FitBest:
+		return "best"
+	case FitFirst:
+		return "first"
+	case FitNext:
+		return "next"
+	case FitWorst:
+		return "worst"
+	}
+	return "unknown"
+}
+
+// checkFree reports whether [offset, offset+size) collides with the free
+// blocks around it: prevEnd is the End of the block with the greatest Start
+// not after offset and nextStart the Start of the block after it. Pass
+// offset and offset+size for a missing block.
+func checkFree(prevEnd, nextStart, offset, size int) error {
+	if prevEnd > offset {
+		if prevEnd >= offset+size {
+			return ErrDoubleFree
+		}
+		return ErrOverlap
+	}
+	if nextStart < offset+size {
+		return ErrOverlap
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+//go:build !disable_rm
+
+package gomem
+
+import (
+	"cmp"
+	"slices"
+	"unsafe"
+)
+
+// Handle refers to memory allocated with MallocHandle. Unlike a []byte it
+// stays valid when Defragment moves the memory. The zero Handle is invalid.
+type Handle int
+
+// MallocHandle allocates size bytes that Defragment may move, and returns a
+// handle to them, or the zero Handle when the allocation fails.
+func (sma *ScalableMemoryAllocator) MallocHandle(size int) (h Handle) {
+	if size <= 0 {
+		return
+	}
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	memory, err := sma.malloc(size, 0)
+	if err != nil {
+		return
+	}
+	sma.addMallocCount(size)
+	if n := len(sma.freeHandles); n > 0 {
+		h = sma.freeHandles[n-1]
+		sma.freeHandles = sma.freeHandles[:n-1]
+	} else {
+		sma.handles = append(sma.handles, nil)
+		h = Handle(len(sma.handles))
+	}
+	sma.handles[h-1] = memory
+	return
+}
+
+// Deref returns the memory of h, or nil when h is not a live handle of sma.
+// The slice must not be kept across a call to Defragment, which may move it.
+func (sma *ScalableMemoryAllocator) Deref(h Handle) []byte {
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	if h <= 0 || int(h) > len(sma.handles) {
+		return nil
+	}
+	return sma.handles[h-1]
+}
+
+// FreeHandle frees the memory of h and reports whether h was a live handle.
+func (sma *ScalableMemoryAllocator) FreeHandle(h Handle) bool {
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	if h <= 0 || int(h) > len(sma.handles) || sma.handles[h-1] == nil {
+		return false
+	}
+	if sma.free(sma.handles[h-1]) != nil {
+		return false
+	}
+	sma.handles[h-1] = nil
+	sma.freeHandles = append(sma.freeHandles, h)
+	return true
+}
+
+// Defragment moves handle-backed memory toward the low offsets of the most
+// used children, so that the free space coalesces and children holding only
+// moved memory become empty and are released. It copies at most budget
+// bytes, so a long compaction can be spread over several calls, and returns
+// the number of bytes moved.
+func (sma *ScalableMemoryAllocator) Defragment(budget int) (moved int) {
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	type live struct {
+		h            Handle
+		child, start int // rank of the child, offset in it
+	}
+	// rank children by bytes in use, densest first
+	ranked := slices.Clone(sma.children)
+	inUse := make(map[*MemoryAllocator]int, len(ranked))
+	for _, child := range ranked {
+		inUse[child] = child.Size - child.allocator.GetFreeSize()
+	}
+	slices.SortStableFunc(ranked, func(x, y *MemoryAllocator) int {
+		return cmp.Compare(inUse[y], inUse[x])
+	})
+	rank := make(map[*MemoryAllocator]int, len(ranked))
+	for i, child := range ranked {
+		rank[child] = i
+	}
+	var lives []live
+	for i, memory := range sma.handles {
+		if memory == nil {
+			continue
+		}
+		if child, start := sma.findChild(int64(uintptr(unsafe.Pointer(&memory[0])))); child != nil {
+			lives = append(lives, live{Handle(i + 1), rank[child], start})
+		}
+	}
+	// move the memory furthest from the front first
+	slices.SortFunc(lives, func(x, y live) int {
+		if x.child != y.child {
+			return y.child - x.child
+		}
+		return y.start - x.start
+	})
+	for _, l := range lives {
+		memory := sma.handles[l.h-1]
+		size := len(memory)
+		if size > budget {
+			continue
+		}
+		for r, child := range ranked[:l.child+1] {
+			offset := sma.allocateLow(child, size)
+			if offset == -1 {
+				continue
+			}
+			if r == l.child && offset >= l.start {
+				// no lower room in its own child
+				child.allocator.Free(offset, size)
+				break
+			}
+			target := child.memory[offset : offset+size]
+			copy(target, memory)
+			ranked[l.child].allocator.Free(l.start, size)
+			sma.handles[l.h-1] = target
+			moved += size
+			budget -= size
+			break
+		}
+	}
+	sma.Trim()
+	return
+}
+
+// allocateLow allocates size bytes at the lowest offset of child that fits,
+// as far as its strategy allows
+func (sma *ScalableMemoryAllocator) allocateLow(child *MemoryAllocator, size int) int {
+	child.allocator.SetFitPolicy(FitFirst)
+	defer child.allocator.SetFitPolicy(sma.config.policy)
+	return child.allocator.Allocate(size)
+}
@@ -0,0 +1,51 @@
+package gomem
+
+import "testing"
+
+func TestScalableMemoryAllocatorDefragment(t *testing.T) {
+	for _, strategy := range strategies {
+		sma := NewScalableMemoryAllocator(1<<12, WithStrategy(strategy))
+		var handles []Handle
+		for i := range 64 {
+			h := sma.MallocHandle(256)
+			for j := range sma.Deref(h) {
+				sma.Deref(h)[j] = byte(i + j)
+			}
+			handles = append(handles, h)
+		}
+		for i, h := range handles {
+			if i%4 != 0 && !sma.FreeHandle(h) {
+				t.Fatalf("%v: FreeHandle(%d) failed", strategy, h)
+			}
+		}
+		before := len(sma.GetChildren())
+		if moved := sma.Defragment(0); moved != 0 {
+			t.Fatalf("%v: moved %d bytes without budget", strategy, moved)
+		}
+		if moved := sma.Defragment(1 << 20); moved == 0 {
+			t.Fatalf("%v: nothing moved", strategy)
+		}
+		if after := len(sma.GetChildren()); after >= before {
+			t.Fatalf("%v: %d children before, %d after", strategy, before, after)
+		}
+		for i := 0; i < len(handles); i += 4 {
+			for j, b := range sma.Deref(handles[i]) {
+				if b != byte(i+j) {
+					t.Fatalf("%v: handle %d corrupted at %d", strategy, handles[i], j)
+				}
+			}
+		}
+		for _, child := range sma.GetChildren() {
+			if err := child.allocator.Validate(); err != nil {
+				t.Fatalf("%v: %v", strategy, err)
+			}
+		}
+		if stats := sma.Stats(); stats.InUse != 16*256 {
+			t.Fatalf("%v: in use %d, want %d", strategy, stats.InUse, 16*256)
+		}
+		for i := 0; i < len(handles); i += 4 {
+			sma.FreeHandle(handles[i])
+		}
+		sma.Recycle()
+	}
+}
@@ -0,0 +1,35 @@
+package gomem
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestScalableMemoryAllocatorHuge(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 12)
+	defer sma.Recycle()
+	huge := sma.Malloc(MaxBlockSize + 1)
+	aligned := sma.MallocAligned(MaxBlockSize, 1<<12)
+	if len(huge) != MaxBlockSize+1 || uintptr(unsafe.Pointer(&aligned[0]))%(1<<12) != 0 {
+		t.Fatal("huge allocation has the wrong size or alignment")
+	}
+	huge[len(huge)-1] = 1
+	stats := sma.Stats()
+	if stats.Children != 3 || stats.InUse != 2*MaxBlockSize+1 || stats.Reserved < 1<<12+2*MaxBlockSize+1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	// a part is freed like any other allocation
+	if !sma.Free(huge[MaxBlockSize:]) || sma.Free(huge[MaxBlockSize:]) {
+		t.Fatal("partial free of a huge allocation")
+	}
+	if !sma.Free(huge[:MaxBlockSize]) || !sma.Free(aligned) {
+		t.Fatal("huge allocation not owned")
+	}
+	if stats := sma.Stats(); stats.Children != 1 || stats.InUse != 0 || stats.Reserved != 1<<12 {
+		t.Fatalf("huge children not released: %+v", stats)
+	}
+	limited := NewScalableMemoryAllocator(1<<12, WithQuota(MaxBlockSize))
+	if limited.Malloc(MaxBlockSize+1) != nil {
+		t.Fatal("huge allocation grew past the quota")
+	}
+}
@@ -0,0 +1,159 @@
+package gomem
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// MetricsRegistry collects the statistics of the ScalableMemoryAllocators
+	// registered into it, summed by name, so that the shards of a
+	// ShardedAllocator may share one. It is an expvar.Var, to publish with
+	// expvar.Publish, and an http.Handler serving the Prometheus text format.
+	MetricsRegistry struct {
+		mu         sync.Mutex
+		allocators map[*ScalableMemoryAllocator]string
+		retired    map[string]*metricsGroup // counters of unregistered allocators
+	}
+	metricsGroup struct {
+		Allocators      int     `json:"allocators"`
+		InUse           int64   `json:"in_use_bytes"`
+		Reserved        int     `json:"reserved_bytes"`
+		Children        int     `json:"children"`
+		TotalMalloc     int64   `json:"malloc_bytes"`
+		TotalFree       int64   `json:"free_bytes"`
+		ChildrenCreated int64   `json:"child_creations"`
+		ChildrenTrimmed int64   `json:"child_trims"`
+		MallocFailures  int64   `json:"malloc_failures"`
+		Fragmentation   float64 `json:"fragmentation"`
+		free            FreeSpaceStats
+	}
+	metricsSnapshot struct {
+		BuddyFallbacks int64                    `json:"buddy_fallbacks"`
+		Allocators     map[string]*metricsGroup `json:"allocators"`
+	}
+)
+
+// NewMetricsRegistry creates an empty registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		allocators: make(map[*ScalableMemoryAllocator]string),
+		retired:    make(map[string]*metricsGroup),
+	}
+}
+
+// Register adds sma to the registry under name, which may be empty.
+// Registering it again renames it.
+func (r *MetricsRegistry) Register(name string, sma *ScalableMemoryAllocator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allocators[sma] = name
+}
+
+// Unregister removes sma from the registry. Its counters stay in the totals of
+// its name, so that they never go down.
+func (r *MetricsRegistry) Unregister(sma *ScalableMemoryAllocator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name, ok := r.allocators[sma]
+	if !ok {
+		return
+	}
+	delete(r.allocators, sma)
+	group := r.retired[name]
+	if group == nil {
+		group = &metricsGroup{}
+		r.retired[name] = group
+	}
+	stats := sma.Stats()
+	group.TotalMalloc += stats.TotalMalloc
+	group.TotalFree += stats.TotalFree
+	group.ChildrenCreated += stats.ChildrenCreated
+	group.ChildrenTrimmed += stats.ChildrenTrimmed
+	group.MallocFailures += stats.MallocFailures
+}
+
+// snapshot reads the statistics of every allocator, grouped by name
+func (r *MetricsRegistry) snapshot() (snapshot metricsSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot.BuddyFallbacks = buddyFallbacks()
+	snapshot.Allocators = make(map[string]*metricsGroup, len(r.retired))
+	for name, retired := range r.retired {
+		group := *retired
+		snapshot.Allocators[name] = &group
+	}
+	for sma, name := range r.allocators {
+		group := snapshot.Allocators[name]
+		if group == nil {
+			group = &metricsGroup{}
+			snapshot.Allocators[name] = group
+		}
+		stats := sma.Stats()
+		group.Allocators++
+		group.InUse += stats.InUse
+		group.Reserved += stats.Reserved
+		group.Children += stats.Children
+		group.TotalMalloc += stats.TotalMalloc
+		group.TotalFree += stats.TotalFree
+		group.ChildrenCreated += stats.ChildrenCreated
+		group.ChildrenTrimmed += stats.ChildrenTrimmed
+		group.MallocFailures += stats.MallocFailures
+		group.free.Merge(&stats.Free)
+	}
+	for _, group := range snapshot.Allocators {
+		group.Fragmentation = group.free.Fragmentation()
+	}
+	return
+}
+
+// String returns the metrics as JSON, for expvar.
+func (r *MetricsRegistry) String() string {
+	data, _ := json.Marshal(r.snapshot())
+	return string(data)
+}
+
+var prometheusMetrics = []struct {
+	name, kind, help string
+	value            func(*metricsGroup) string
+}{
+	{"gomem_allocators", "gauge", "Registered allocators.", func(g *metricsGroup) string { return strconv.Itoa(g.Allocators) }},
+	{"gomem_in_use_bytes", "gauge", "Bytes allocated and not freed.", func(g *metricsGroup) string { return strconv.FormatInt(g.InUse, 10) }},
+	{"gomem_reserved_bytes", "gauge", "Total size of the children.", func(g *metricsGroup) string { return strconv.Itoa(g.Reserved) }},
+	{"gomem_children", "gauge", "Children of the allocators.", func(g *metricsGroup) string { return strconv.Itoa(g.Children) }},
+	{"gomem_malloc_bytes_total", "counter", "Bytes allocated.", func(g *metricsGroup) string { return strconv.FormatInt(g.TotalMalloc, 10) }},
+	{"gomem_free_bytes_total", "counter", "Bytes freed.", func(g *metricsGroup) string { return strconv.FormatInt(g.TotalFree, 10) }},
+	{"gomem_child_creations_total", "counter", "Children added to the allocators.", func(g *metricsGroup) string { return strconv.FormatInt(g.ChildrenCreated, 10) }},
+	{"gomem_child_trims_total", "counter", "Children released by Trim or Free.", func(g *metricsGroup) string { return strconv.FormatInt(g.ChildrenTrimmed, 10) }},
+	{"gomem_malloc_failures_total", "counter", "Malloc calls that got no memory.", func(g *metricsGroup) string { return strconv.FormatInt(g.MallocFailures, 10) }},
+	{"gomem_fragmentation_ratio", "gauge", "1 - largest free block / free bytes.", func(g *metricsGroup) string { return strconv.FormatFloat(g.Fragmentation, 'g', -1, 64) }},
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format, with
+// an allocator label holding the name.
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	snapshot := r.snapshot()
+	names := make([]string, 0, len(snapshot.Allocators))
+	for name := range snapshot.Allocators {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var buf bytes.Buffer
+	for _, metric := range prometheusMetrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "%s{allocator=\"%s\"} %s\n", metric.name, labelEscaper.Replace(name), metric.value(snapshot.Allocators[name]))
+		}
+	}
+	fmt.Fprintf(&buf, "# HELP gomem_buddy_fallbacks_total Children created outside the default buddy arena.\n# TYPE gomem_buddy_fallbacks_total counter\ngomem_buddy_fallbacks_total %d\n", snapshot.BuddyFallbacks)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,56 @@
+package gomem
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	registry := NewMetricsRegistry()
+	a := NewScalableMemoryAllocator(1<<12, WithMetrics(registry, "a"), WithQuota(1<<13))
+	b := NewScalableMemoryAllocator(1<<12, WithMetrics(registry, `b"`))
+	defer b.Recycle()
+	first, second := a.Malloc(1<<12), a.Malloc(1<<12)
+	if a.Malloc(1) != nil {
+		t.Fatal("Malloc grew past the quota")
+	}
+	a.Free(second) // drops the second child
+	b.Malloc(100)
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE gomem_in_use_bytes gauge",
+		`gomem_in_use_bytes{allocator="a"} 4096`,
+		`gomem_in_use_bytes{allocator="b\""} 100`,
+		`gomem_reserved_bytes{allocator="a"} 4096`,
+		`gomem_children{allocator="a"} 1`,
+		`gomem_child_creations_total{allocator="a"} 2`,
+		`gomem_child_trims_total{allocator="a"} 1`,
+		`gomem_malloc_failures_total{allocator="a"} 1`,
+		`gomem_fragmentation_ratio{allocator="a"} 0`,
+		"gomem_buddy_fallbacks_total " + strconv.FormatInt(buddyFallbacks(), 10),
+	} {
+		if !strings.Contains(body, "\n"+line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+
+	a.Free(first)
+	a.Recycle()
+	var snapshot metricsSnapshot
+	if err := json.Unmarshal([]byte(registry.String()), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// the counters of a recycled allocator remain
+	if group := snapshot.Allocators["a"]; group == nil || group.Allocators != 0 || group.ChildrenCreated != 2 || group.TotalMalloc != 2<<12 {
+		t.Fatalf("a after Recycle: %+v", group)
+	}
+	if group := snapshot.Allocators[`b"`]; group == nil || group.Allocators != 1 || group.InUse != 100 {
+		t.Fatalf("b: %+v", group)
+	}
+}
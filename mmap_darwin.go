@@ -25,7 +25,7 @@ func createMemoryAllocator(size int) *MemoryAllocator {
 
 	start := int64(uintptr(unsafe.Pointer(&memory[0])))
 	ret := &MemoryAllocator{
-		allocator: NewAllocator(size),
+		allocator: NewFreeSpaceIndex(DefaultStrategy, size),
 		Size:      size,
 		memory:    memory,
 		start:     start,
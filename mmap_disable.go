@@ -6,7 +6,7 @@ import "unsafe"
 func createMemoryAllocator(size int) *MemoryAllocator {
 	memory := make([]byte, size)
 	ret := &MemoryAllocator{
-		allocator: NewAllocator(size),
+		allocator: NewFreeSpaceIndex(DefaultStrategy, size),
 		Size:      size,
 		memory:    memory,
 		start:     int64(uintptr(unsafe.Pointer(&memory[0]))),
@@ -41,16 +41,34 @@ func createMemoryAllocator(size int) *MemoryAllocator {
 
 	start := int64(uintptr(unsafe.Pointer(&memory[0])))
 	ret := &MemoryAllocator{
-		allocator: NewAllocator(size),
+		allocator: NewFreeSpaceIndex(DefaultStrategy, size),
 		Size:      size,
 		memory:    memory,
 		start:     start,
 		recycle: func() {
 			// Release the mmap allocated memory
-			if err := syscall.Munmap(memory); err != nil {
+			if err := munmap(memory, size); err != nil {
 				panic(fmt.Sprintf("munmap failed: %v", err))
 			}
 		},
+		resize: func(newSize int) []byte {
+			// Without MREMAP_MAYMOVE the mapping keeps its address, so the
+			// slices handed out stay valid; growing fails if the pages after
+			// it are taken
+			_, _, errno := syscall.Syscall6(
+				syscall.SYS_MREMAP,
+				uintptr(unsafe.Pointer(&memory[0])),
+				uintptr(len(memory)),
+				uintptr(newSize),
+				0, 0, 0,
+			)
+			if errno != 0 {
+				return nil
+			}
+			// recycle unmaps the resized mapping
+			memory = unsafe.Slice(&memory[0], newSize)
+			return memory
+		},
 	}
 	ret.allocator.Init(size)
 	return ret
@@ -72,3 +90,22 @@ func madvise(b []byte, advice int) error {
 	}
 	return nil
 }
+
+// munmap releases memory mapped with syscall.Mmap at mappedSize bytes. The
+// syscall package only knows the mapping at its original size, so a mapping
+// resized by mremap is released with a raw syscall.
+func munmap(memory []byte, mappedSize int) error {
+	if len(memory) == mappedSize {
+		return syscall.Munmap(memory)
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_MUNMAP,
+		uintptr(unsafe.Pointer(&memory[0])),
+		uintptr(len(memory)),
+		0,
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build linux && enable_mmap
+
+package gomem
+
+import "testing"
+
+func TestMemoryAllocatorGrowShrink(t *testing.T) {
+	ma := createMemoryAllocator(1 << 16)
+	defer ma.Recycle()
+	mem := ma.Malloc(1 << 15)
+	mem[0] = 42
+	if !ma.Shrink(1 << 15) {
+		t.Fatal("could not shrink to the allocated bytes")
+	}
+	if ma.Malloc(1) != nil {
+		t.Fatal("allocated past the shrunk size")
+	}
+	if !ma.Grow(1 << 17) {
+		t.Skip("the mapping cannot grow in place here")
+	}
+	tail := ma.Malloc(1 << 16)
+	if tail == nil {
+		t.Fatal("no room after Grow")
+	}
+	tail[len(tail)-1] = 1 // the grown pages are mapped
+	if mem[0] != 42 || ma.Size != 1<<17 || len(ma.memory) != 1<<17 {
+		t.Fatalf("size %d, memory %d", ma.Size, len(ma.memory))
+	}
+}
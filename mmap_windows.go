@@ -42,7 +42,7 @@ func createMemoryAllocator(size int) *MemoryAllocator {
 	start := int64(ptr)
 
 	ret := &MemoryAllocator{
-		allocator: NewAllocator(size),
+		allocator: NewFreeSpaceIndex(DefaultStrategy, size),
 		Size:      size,
 		memory:    memory,
 		start:     start,
@@ -0,0 +1,77 @@
+package gomem
+
+import "errors"
+
+var (
+	// ErrQuotaExceeded is returned by TryMalloc and TryRealloc when growing
+	// the allocator would take it over the quota set with WithQuota.
+	ErrQuotaExceeded = errors.New("gomem: allocator quota exceeded")
+	// ErrTooLarge is returned by TryMalloc and TryRealloc for sizes over
+	// MaxBlockSize.
+	ErrTooLarge = errors.New("gomem: allocation larger than MaxBlockSize")
+	// ErrRecycled is returned by TryMalloc when the allocator is recycled
+	// while it waits for a BuddyBlock arena.
+	ErrRecycled = errors.New("gomem: allocator recycled while growing")
+)
+
+type (
+	scalableConfig struct {
+		strategy AllocatorStrategy
+		policy   FitPolicy
+		recorder *TraceRecorder
+		quota    int
+		metrics  *MetricsRegistry
+		name     string
+	}
+	// ScalableOption configures a ScalableMemoryAllocator at creation time.
+	ScalableOption func(*scalableConfig)
+)
+
+func newScalableConfig(opts []ScalableOption) (config scalableConfig) {
+	config.strategy = DefaultStrategy
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return
+}
+
+// WithStrategy makes every child of the allocator index its free space with
+// the given strategy instead of DefaultStrategy.
+func WithStrategy(strategy AllocatorStrategy) ScalableOption {
+	return func(c *scalableConfig) {
+		c.strategy = strategy
+	}
+}
+
+// WithFitPolicy makes every child of the allocator pick free blocks with the
+// given policy. The TLSF strategy ignores it.
+func WithFitPolicy(policy FitPolicy) ScalableOption {
+	return func(c *scalableConfig) {
+		c.policy = policy
+	}
+}
+
+// WithTraceRecorder records every Malloc, Free and Borrow on the children of
+// the allocator to recorder.
+func WithTraceRecorder(recorder *TraceRecorder) ScalableOption {
+	return func(c *scalableConfig) {
+		c.recorder = recorder
+	}
+}
+
+// WithQuota caps the total size of the children of the allocator at bytes.
+// Malloc returns nil and TryMalloc ErrQuotaExceeded instead of growing past
+// it; the first child is not created when the initial size exceeds it.
+func WithQuota(bytes int) ScalableOption {
+	return func(c *scalableConfig) {
+		c.quota = bytes
+	}
+}
+
+// WithMetrics registers the allocator into registry under name, which may be
+// empty, until it is recycled.
+func WithMetrics(registry *MetricsRegistry, name string) ScalableOption {
+	return func(c *scalableConfig) {
+		c.metrics, c.name = registry, name
+	}
+}
@@ -0,0 +1,63 @@
+package gomem
+
+import "testing"
+
+func TestScalableMemoryAllocatorQuota(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1<<12, WithQuota(1<<14))
+	defer sma.Recycle()
+	var blocks [][]byte
+	for {
+		mem, err := sma.TryMalloc(1 << 10)
+		if err == ErrQuotaExceeded {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, mem)
+	}
+	if stats := sma.Stats(); stats.Reserved > 1<<14 || len(blocks) != 16 {
+		t.Fatalf("%d blocks, stats %+v", len(blocks), stats)
+	}
+	if sma.Malloc(1) != nil {
+		t.Fatal("Malloc grew past the quota")
+	}
+	if _, err := sma.TryMalloc(MaxBlockSize + 1); err != ErrTooLarge {
+		t.Fatalf("huge TryMalloc: %v", err)
+	}
+	sma.Free(blocks[3])
+	if mem, err := sma.TryMalloc(1 << 10); err != nil || &mem[0] != &blocks[3][0] {
+		t.Fatalf("TryMalloc after Free: %v", err)
+	}
+	// a Realloc that cannot grow keeps the old buffer owned and intact
+	old := blocks[5]
+	copy(old, "frame")
+	if sma.Realloc(old, 1<<11) != nil {
+		t.Fatal("Realloc grew past the quota")
+	}
+	if mem, err := sma.TryRealloc(old, 1<<11); err != ErrQuotaExceeded || mem != nil {
+		t.Fatalf("TryRealloc past the quota: %v", err)
+	}
+	if mem, err := sma.TryRealloc(old, MaxBlockSize+1); err != ErrTooLarge || mem != nil {
+		t.Fatalf("huge TryRealloc: %v", err)
+	}
+	if string(old[:5]) != "frame" {
+		t.Fatal("a failed Realloc changed the buffer")
+	}
+	if err := sma.TryFree(old); err != nil {
+		t.Fatalf("free after a failed Realloc: %v", err)
+	}
+	if mem, err := sma.TryRealloc(blocks[4], 1<<11); err != nil || &mem[0] != &blocks[4][0] {
+		t.Fatalf("TryRealloc in place into the freed block: %v", err)
+	}
+	if sma := NewScalableMemoryAllocator(1<<12, WithQuota(1<<10)); len(sma.GetChildren()) != 0 {
+		t.Fatal("the first child exceeds the quota")
+	}
+	var none *ScalableMemoryAllocator
+	if mem, err := none.TryMalloc(100); err != nil || len(mem) != 100 {
+		t.Fatalf("TryMalloc on a nil allocator: %d bytes, %v", len(mem), err)
+	}
+	if _, err := none.TryMalloc(MaxBlockSize + 1); err != ErrTooLarge {
+		t.Fatalf("huge TryMalloc on a nil allocator: %v", err)
+	}
+}
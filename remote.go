@@ -0,0 +1,69 @@
+//go:build !disable_rm
+
+package gomem
+
+import "sync"
+
+// remoteFree is a node of the lock-free stack behind FreeRemote
+type remoteFree struct {
+	mem  []byte
+	next *remoteFree
+}
+
+var remoteFreePool = sync.Pool{
+	New: func() any {
+		return new(remoteFree)
+	},
+}
+
+// FreeRemote queues mem to be freed by the goroutine that owns sma, and may
+// be called from any goroutine without taking sma's lock. The queue is
+// drained by the next Malloc, MallocAligned, Borrow or Free on sma, or by
+// DrainRemote. Memory that turns out not to belong to sma is dropped.
+func (sma *ScalableMemoryAllocator) FreeRemote(mem []byte) {
+	if sma == nil || len(mem) == 0 {
+		return
+	}
+	node := remoteFreePool.Get().(*remoteFree)
+	node.mem = mem
+	// the drainer takes the whole stack at once, so a head that was popped
+	// and pushed again in between is still the head: no ABA problem
+	for {
+		head := sma.remote.Load()
+		node.next = head
+		if sma.remote.CompareAndSwap(head, node) {
+			return
+		}
+	}
+}
+
+// DrainRemote frees the memory queued by FreeRemote and returns how many
+// buffers it freed.
+func (sma *ScalableMemoryAllocator) DrainRemote() int {
+	if sma == nil {
+		return 0
+	}
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	return sma.drainRemote()
+}
+
+// drainPending drains the remote frees, if any, with sma locked
+func (sma *ScalableMemoryAllocator) drainPending() {
+	if sma.remote.Load() != nil {
+		sma.drainRemote()
+	}
+}
+
+func (sma *ScalableMemoryAllocator) drainRemote() (freed int) {
+	for node := sma.remote.Swap(nil); node != nil; {
+		if sma.free(node.mem) == nil {
+			freed++
+		}
+		next := node.next
+		node.mem, node.next = nil, nil
+		remoteFreePool.Put(node)
+		node = next
+	}
+	return
+}
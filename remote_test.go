@@ -0,0 +1,45 @@
+package gomem
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestScalableMemoryAllocatorFreeRemote(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 16)
+	defer sma.Recycle()
+	frames := make(chan []byte)
+	var subscribers sync.WaitGroup
+	for range 4 {
+		subscribers.Add(1)
+		go func() {
+			defer subscribers.Done()
+			for frame := range frames {
+				sma.FreeRemote(frame)
+			}
+		}()
+	}
+	// the owner keeps allocating, which drains the queue as it goes
+	for i := range 1000 {
+		frames <- sma.Malloc(64 + i%512)
+	}
+	close(frames)
+	subscribers.Wait()
+	sma.DrainRemote()
+	if stats := sma.Stats(); stats.InUse != 0 || stats.TotalMalloc == 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+	// a queued free is applied by the next Free
+	a, b := sma.Malloc(100), sma.Malloc(100)
+	sma.FreeRemote(a)
+	if sma.GetTotalFree() == sma.GetTotalMalloc()-100 {
+		t.Fatal("FreeRemote freed without the owner")
+	}
+	if !sma.Free(b) || sma.GetTotalFree() != sma.GetTotalMalloc() {
+		t.Fatal("Free did not drain the remote frees")
+	}
+	sma.FreeRemote(make([]byte, 10))
+	if n := sma.DrainRemote(); n != 0 {
+		t.Fatalf("drained %d foreign buffers", n)
+	}
+}
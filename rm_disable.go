@@ -55,27 +55,116 @@ func (*MemoryAllocator) GetBlocks() (blocks []*Block) {
 	return nil
 }
 
-type ScalableMemoryAllocator struct {
+func (*MemoryAllocator) Stats() (stats FreeSpaceStats) {
+	return
 }
 
-func NewScalableMemoryAllocator(size int) (ret *ScalableMemoryAllocator) {
-	return nil
+func (*MemoryAllocator) SetStrategy(strategy AllocatorStrategy) {
+}
+
+func (*MemoryAllocator) SetFitPolicy(policy FitPolicy) {
+}
+
+func (*MemoryAllocator) Grow(newSize int) bool {
+	return false
+}
+
+func (*MemoryAllocator) Shrink(newSize int) bool {
+	return false
+}
+
+type (
+	ScalableMemoryAllocator struct {
+		handles [][]byte
+	}
+	Handle int
+)
+
+func NewScalableMemoryAllocator(size int, opts ...ScalableOption) (ret *ScalableMemoryAllocator) {
+	return &ScalableMemoryAllocator{}
+}
+
+func (sma *ScalableMemoryAllocator) MallocHandle(size int) Handle {
+	sma.handles = append(sma.handles, make([]byte, size))
+	return Handle(len(sma.handles))
+}
+
+func (sma *ScalableMemoryAllocator) Deref(h Handle) []byte {
+	if h <= 0 || int(h) > len(sma.handles) {
+		return nil
+	}
+	return sma.handles[h-1]
+}
+
+func (sma *ScalableMemoryAllocator) FreeHandle(h Handle) bool {
+	if h <= 0 || int(h) > len(sma.handles) || sma.handles[h-1] == nil {
+		return false
+	}
+	sma.handles[h-1] = nil
+	return true
+}
+
+func (*ScalableMemoryAllocator) Defragment(budget int) int {
+	return 0
+}
+
+func (*ScalableMemoryAllocator) FreeRemote(mem []byte) {
+}
+
+func (*ScalableMemoryAllocator) DrainRemote() int {
+	return 0
 }
 
 func (*ScalableMemoryAllocator) Malloc(size int) (memory []byte) {
 	return make([]byte, size)
 }
 
+func (*ScalableMemoryAllocator) TryMalloc(size int) ([]byte, error) {
+	if size > MaxBlockSize {
+		return nil, ErrTooLarge
+	}
+	return make([]byte, size), nil
+}
+
+func (*ScalableMemoryAllocator) MallocAligned(size, align int) (memory []byte) {
+	return makeAligned(size, align)
+}
+
 func (*ScalableMemoryAllocator) FreeRest(mem *[]byte, keep int) {
 	if m := *mem; keep < len(m) {
 		*mem = m[:keep]
 	}
 }
 
+func (*ScalableMemoryAllocator) Realloc(mem []byte, newSize int) []byte {
+	if newSize <= len(mem) {
+		return mem[:newSize]
+	}
+	ret := make([]byte, newSize)
+	copy(ret, mem)
+	return ret
+}
+
+func (*ScalableMemoryAllocator) TryRealloc(mem []byte, newSize int) ([]byte, error) {
+	if newSize <= len(mem) {
+		return mem[:newSize], nil
+	}
+	if newSize > MaxBlockSize {
+		return nil, ErrTooLarge
+	}
+	ret := make([]byte, newSize)
+	copy(ret, mem)
+	return ret, nil
+}
+
 func (*ScalableMemoryAllocator) GetChildren() []*MemoryAllocator {
 	return nil
 }
 
+func (*ScalableMemoryAllocator) Stats() (stats ScalableStats) {
+	return
+}
+
 func (*ScalableMemoryAllocator) Read(reader io.Reader, n int) (mem []byte, err error) {
 	mem = make([]byte, n)
 	n, err = reader.Read(mem)
@@ -92,3 +181,11 @@ func (*ScalableMemoryAllocator) Recycle() {
 func (*ScalableMemoryAllocator) Free(mem []byte) bool {
 	return true
 }
+
+func (*ScalableMemoryAllocator) TryFree(mem []byte) error {
+	return nil
+}
+
+func buddyFallbacks() int64 {
+	return 0
+}
@@ -45,10 +45,12 @@ Example usage:
 package gomem
 
 import (
+	"cmp"
 	"fmt"
 	"io"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -114,11 +116,14 @@ func (r *RecyclableMemory) Recycle() {
 }
 
 type MemoryAllocator struct {
-	allocator *Allocator
+	allocator FreeSpaceIndex
 	start     int64
 	memory    []byte
 	Size      int
 	recycle   func()
+	// resize changes the size of memory in place and returns it, or nil when
+	// it can't. Only set for mappings that support it.
+	resize func(newSize int) []byte
 }
 
 func (ma *MemoryAllocator) Recycle() {
@@ -142,12 +147,44 @@ func (ma *MemoryAllocator) Malloc(size int) (memory []byte) {
 	return
 }
 
-func (ma *MemoryAllocator) free(start, size int) (ret bool) {
-	if start < 0 || start+size > ma.Size {
+// MallocAligned allocates size bytes whose address is a multiple of align.
+func (ma *MemoryAllocator) MallocAligned(size, align int) (memory []byte) {
+	if align <= 1 {
+		return ma.Malloc(size)
+	}
+	if ma.start%int64(align) == 0 {
+		if offset := ma.allocator.AllocateAligned(size, align); offset != -1 {
+			memory = ma.memory[offset : offset+size]
+		}
 		return
 	}
-	ma.allocator.Free(start, size)
-	return true
+	// the arena itself is not aligned: over-allocate and give both ends back
+	offset := ma.allocator.Allocate(size + align - 1)
+	if offset == -1 {
+		return
+	}
+	pad := alignUp(int(ma.start)+offset, align) - int(ma.start) - offset
+	if pad > 0 {
+		ma.allocator.Free(offset, pad)
+	}
+	if rest := align - 1 - pad; rest > 0 {
+		ma.allocator.Free(offset+pad+size, rest)
+	}
+	return ma.memory[offset+pad : offset+pad+size]
+}
+
+func (ma *MemoryAllocator) free(start, size int) error {
+	if start < 0 || start+size > ma.Size {
+		return ErrNotOwned
+	}
+	return ma.allocator.Free(start, size)
+}
+
+func (ma *MemoryAllocator) extend(start, oldSize, newSize int) bool {
+	if start < 0 || start+newSize > ma.Size {
+		return false
+	}
+	return ma.allocator.Extend(start, oldSize, newSize)
 }
 
 // GetBlocks return the blocks of the allocator
@@ -155,17 +192,155 @@ func (ma *MemoryAllocator) GetBlocks() (blocks []*Block) {
 	return ma.allocator.GetBlocks()
 }
 
+// Stats returns the free-space statistics of the allocator
+func (ma *MemoryAllocator) Stats() FreeSpaceStats {
+	return ma.allocator.Stats()
+}
+
+// SetStrategy switches the free space index to the given strategy.
+// It must only be called while nothing is allocated from ma.
+func (ma *MemoryAllocator) SetStrategy(strategy AllocatorStrategy) {
+	if ma.allocator.Strategy() != strategy {
+		ma.allocator = NewFreeSpaceIndex(strategy, ma.Size)
+	}
+}
+
+// Grow enlarges ma to newSize bytes in place and reports whether it could.
+// Only mmap-backed allocators on Linux (built with enable_mmap) can grow.
+func (ma *MemoryAllocator) Grow(newSize int) bool {
+	if ma.resize == nil || newSize <= ma.Size {
+		return newSize == ma.Size
+	}
+	memory := ma.resize(newSize)
+	if memory == nil {
+		return false
+	}
+	ma.memory = memory
+	ma.allocator.Grow(newSize)
+	ma.Size = newSize
+	return true
+}
+
+// Shrink reduces ma to newSize bytes and reports whether it could, which
+// requires its tail from newSize on to be free.
+func (ma *MemoryAllocator) Shrink(newSize int) bool {
+	if ma.resize == nil || newSize >= ma.Size {
+		return newSize == ma.Size
+	}
+	if !ma.allocator.Shrink(newSize) {
+		return false
+	}
+	if memory := ma.resize(newSize); memory != nil {
+		ma.memory = memory
+	} else {
+		// keep the mapping as it is
+		ma.allocator.Grow(ma.Size)
+		return false
+	}
+	ma.Size = newSize
+	return true
+}
+
+// SetFitPolicy changes how the free space index picks a block for new
+// allocations.
+func (ma *MemoryAllocator) SetFitPolicy(policy FitPolicy) {
+	ma.allocator.SetFitPolicy(policy)
+}
+
 type ScalableMemoryAllocator struct {
 	mu          sync.Mutex
 	children    []*MemoryAllocator
+	byStart     []*MemoryAllocator                       // children sorted by start, for findChild
+	onChild     func(child *MemoryAllocator, added bool) // set by ShardedAllocator
 	totalMalloc int64
 	totalFree   int64
 	size        int
 	childSize   int
+	config      scalableConfig
+	handles     [][]byte // memory of Handle i+1, nil when freed
+	freeHandles []Handle
+	remote      atomic.Pointer[remoteFree] // stack of FreeRemote calls to drain
+	recycles    int                        // Recycle calls, seen by a newChild that waited
+	// counters reported by Stats
+	childrenCreated int64
+	childrenTrimmed int64
+	mallocFailures  int64
 }
 
-func NewScalableMemoryAllocator(size int) (ret *ScalableMemoryAllocator) {
-	return &ScalableMemoryAllocator{children: []*MemoryAllocator{GetMemoryAllocator(size)}, size: size, childSize: size}
+func NewScalableMemoryAllocator(size int, opts ...ScalableOption) (ret *ScalableMemoryAllocator) {
+	ret = &ScalableMemoryAllocator{childSize: size, config: newScalableConfig(opts)}
+	if ret.config.metrics != nil {
+		ret.config.metrics.Register(ret.config.name, ret)
+	}
+	if quota := ret.config.quota; quota > 0 && size > quota {
+		return
+	}
+	if child := GetMemoryAllocator(size); child != nil {
+		ret.setupChild(child)
+		ret.size = size
+		ret.addChild(child)
+	}
+	return
+}
+
+// addChild appends child to children and indexes it by address
+func (sma *ScalableMemoryAllocator) addChild(child *MemoryAllocator) {
+	sma.children = append(sma.children, child)
+	i, _ := slices.BinarySearchFunc(sma.byStart, child.start, compareStart)
+	sma.byStart = slices.Insert(sma.byStart, i, child)
+	sma.childrenCreated++
+	if sma.onChild != nil {
+		sma.onChild(child, true)
+	}
+}
+
+// unindexChild removes child from byStart, not from children
+func (sma *ScalableMemoryAllocator) unindexChild(child *MemoryAllocator) {
+	if i, found := slices.BinarySearchFunc(sma.byStart, child.start, compareStart); found {
+		sma.byStart = slices.Delete(sma.byStart, i, i+1)
+	}
+	sma.childrenTrimmed++
+	if sma.onChild != nil {
+		sma.onChild(child, false)
+	}
+}
+
+func compareStart(child *MemoryAllocator, start int64) int {
+	return cmp.Compare(child.start, start)
+}
+
+// newChild gets a MemoryAllocator of the given size using the configured
+// strategy. sma must be locked; it is unlocked while a BuddyBlock arena waits,
+// and newChild returns ErrRecycled, giving the child back, when sma was
+// recycled meanwhile.
+func (sma *ScalableMemoryAllocator) newChild(size int) (child *MemoryAllocator, err error) {
+	recycles := sma.recycles
+	if child = getMemoryAllocator(size, &sma.mu); child == nil {
+		return nil, ErrBuddyExhausted
+	}
+	if sma.recycles != recycles {
+		child.Recycle()
+		return nil, ErrRecycled
+	}
+	sma.setupChild(child)
+	return
+}
+
+// setupChild applies the configured strategy, recorder and policy to child
+func (sma *ScalableMemoryAllocator) setupChild(child *MemoryAllocator) {
+	child.SetStrategy(sma.config.strategy)
+	if sma.config.recorder != nil {
+		child.allocator = sma.config.recorder.Trace(child.allocator, child.Size)
+	}
+	// after tracing, so that a replay switches to the same policy
+	child.SetFitPolicy(sma.config.policy)
+}
+
+// dropChild recycles a child that has been removed from children
+func (sma *ScalableMemoryAllocator) dropChild(child *MemoryAllocator) {
+	// unwrap before recycling: a pooled child must not keep our recorder
+	child.allocator = untrace(child.allocator)
+	child.Recycle()
 }
 
 func (sma *ScalableMemoryAllocator) checkSize() {
@@ -208,13 +383,44 @@ func (sma *ScalableMemoryAllocator) GetChildren() []*MemoryAllocator {
 	return sma.children
 }
 
+// Stats returns a snapshot of the allocation counters and the free space of
+// all children. It walks every free block, so avoid calling it on a hot path.
+func (sma *ScalableMemoryAllocator) Stats() (stats ScalableStats) {
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	stats.TotalMalloc, stats.TotalFree = sma.totalMalloc, sma.totalFree
+	stats.InUse = sma.totalMalloc - sma.totalFree
+	stats.Reserved = sma.size
+	stats.Children = len(sma.children)
+	stats.ChildrenCreated, stats.ChildrenTrimmed = sma.childrenCreated, sma.childrenTrimmed
+	stats.MallocFailures = sma.mallocFailures
+	for _, child := range sma.children {
+		childStats := child.allocator.Stats()
+		stats.Free.Merge(&childStats)
+	}
+	return
+}
+
+// Recycle releases every child, and unregisters sma from the registry given
+// with WithMetrics.
 func (sma *ScalableMemoryAllocator) Recycle() {
+	if sma.config.metrics != nil {
+		// deferred first, so that it runs after the unlock: Unregister reads Stats
+		defer sma.config.metrics.Unregister(sma)
+	}
 	sma.mu.Lock()
 	defer sma.mu.Unlock()
 	for _, child := range sma.children {
-		child.Recycle()
+		if sma.onChild != nil {
+			sma.onChild(child, false)
+		}
+		sma.dropChild(child)
 	}
-	sma.children = nil
+	sma.children, sma.byStart = nil, nil
+	sma.handles, sma.freeHandles = nil, nil
+	sma.recycles++
+	// pending remote frees refer to memory released above
+	sma.remote.Store(nil)
 }
 
 // Borrow = Malloc + Free = Find, must use the memory at once
@@ -224,23 +430,19 @@ func (sma *ScalableMemoryAllocator) Borrow(size int) (memory []byte) {
 	}
 	sma.mu.Lock()
 	defer sma.mu.Unlock()
-	defer sma.addMallocCount(size) // LIFO: runs before Unlock, while lock is held
+	sma.drainPending()
 	var child *MemoryAllocator
 	for _, child = range sma.children {
 		if memory = child.Find(size); memory != nil {
+			sma.addMallocCount(size)
 			return
 		}
 	}
-	for sma.childSize < MaxBlockSize {
-		sma.childSize = sma.childSize << 1
-		if sma.childSize >= size {
-			break
-		}
+	if child, _ = sma.addChildFor(size); child == nil {
+		return
 	}
-	child = GetMemoryAllocator(sma.childSize)
-	sma.size += child.Size
 	memory = child.Find(size)
-	sma.children = append(sma.children, child)
+	sma.addMallocCount(size)
 	return
 }
 
@@ -253,9 +455,8 @@ func (sma *ScalableMemoryAllocator) Trim() {
 		if child.allocator.GetFreeSize() == child.Size {
 			// 该子分配器内所有字节均已归还，安全移除以让 GC 回收
 			sma.size -= child.Size
-			if child.recycle != nil {
-				child.recycle()
-			}
+			sma.unindexChild(child)
+			sma.dropChild(child)
 		} else {
 			trimmed = append(trimmed, child)
 		}
@@ -270,16 +471,69 @@ func (sma *ScalableMemoryAllocator) Trim() {
 	}
 }
 
+// Malloc allocates size bytes, or returns nil when the quota set with
+// WithQuota leaves no room. Sizes over MaxBlockSize get a child of their own,
+// which Free releases.
 func (sma *ScalableMemoryAllocator) Malloc(size int) (memory []byte) {
-	if sma == nil || size > MaxBlockSize {
+	if sma == nil {
 		return make([]byte, size)
 	}
 	sma.mu.Lock()
 	defer sma.mu.Unlock()
-	defer sma.addMallocCount(size) // LIFO: runs before Unlock, while lock is held
+	if memory, _ = sma.malloc(size, 1); memory != nil {
+		sma.addMallocCount(size)
+	}
+	return
+}
+
+// TryMalloc is like Malloc but reports why it failed, and takes no more
+// than MaxBlockSize. It returns ErrTooLarge when size exceeds MaxBlockSize,
+// and ErrQuotaExceeded when the quota set with WithQuota leaves no room.
+// Growing into a BuddyArena adds its errors and ErrRecycled.
+func (sma *ScalableMemoryAllocator) TryMalloc(size int) (memory []byte, err error) {
+	if sma == nil {
+		// allocate from the heap like Malloc does
+		if size > MaxBlockSize {
+			return nil, ErrTooLarge
+		}
+		return make([]byte, size), nil
+	}
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	if size > MaxBlockSize {
+		sma.mallocFailures++
+		return nil, ErrTooLarge
+	}
+	if memory, err = sma.malloc(size, 1); err == nil {
+		sma.addMallocCount(size)
+	}
+	return
+}
+
+// MallocAligned is like Malloc but the returned memory starts at an address
+// that is a multiple of align, e.g. 64 for cache lines or 4096 for O_DIRECT.
+func (sma *ScalableMemoryAllocator) MallocAligned(size, align int) (memory []byte) {
+	if sma == nil {
+		return makeAligned(size, align)
+	}
+	sma.mu.Lock()
+	defer sma.mu.Unlock()
+	if memory, _ = sma.malloc(size, align); memory != nil {
+		sma.addMallocCount(size)
+	}
+	return
+}
+
+func (sma *ScalableMemoryAllocator) malloc(size, align int) (memory []byte, err error) {
+	sma.drainPending()
+	// 对齐分配预留最坏情况的填充
+	need := size + max(align, 1) - 1
+	if need > MaxBlockSize {
+		return sma.mallocHuge(size, align, need)
+	}
 	var child *MemoryAllocator
 	for _, child = range sma.children {
-		if memory = child.Malloc(size); memory != nil {
+		if memory = child.MallocAligned(size, align); memory != nil {
 			return
 		}
 	}
@@ -287,24 +541,83 @@ func (sma *ScalableMemoryAllocator) Malloc(size int) (memory []byte) {
 	sma.Trim()
 	// Trim 后再尝试一次已有 children（可能刚被重置过）
 	for _, child = range sma.children {
-		if memory = child.Malloc(size); memory != nil {
+		if memory = child.MallocAligned(size, align); memory != nil {
 			return
 		}
 	}
 	// 仍然不够：扩容
+	// 优先原地扩大最后一个子分配器（仅 Linux mmap 支持），避免子分配器越来越多
+	if n := len(sma.children); n > 0 && sma.growChild(sma.children[n-1], need) {
+		if memory = sma.children[n-1].MallocAligned(size, align); memory != nil {
+			return
+		}
+	}
+	if child, err = sma.addChildFor(need); err != nil {
+		sma.mallocFailures++
+		return
+	}
+	memory = child.MallocAligned(size, align)
+	return
+}
+
+// mallocHuge gives an allocation over MaxBlockSize a child of its own, mapped
+// by createMemoryAllocator, which free releases once it is empty
+func (sma *ScalableMemoryAllocator) mallocHuge(size, align, need int) ([]byte, error) {
+	if quota := sma.config.quota; quota > 0 && sma.size+need > quota {
+		sma.mallocFailures++
+		return nil, ErrQuotaExceeded
+	}
+	child := createMemoryAllocator(need)
+	sma.setupChild(child)
+	sma.size += child.Size
+	sma.addChild(child)
+	return child.MallocAligned(size, align), nil
+}
+
+// addChildFor adds a child with room for need bytes, doubling childSize up
+// to MaxBlockSize without going over the quota
+func (sma *ScalableMemoryAllocator) addChildFor(need int) (child *MemoryAllocator, err error) {
 	for sma.childSize < MaxBlockSize {
 		sma.childSize = sma.childSize << 1
-		if sma.childSize >= size {
+		if sma.childSize >= need {
 			break
 		}
 	}
-	child = GetMemoryAllocator(sma.childSize)
+	size := sma.childSize
+	if quota := sma.config.quota; quota > 0 && sma.size+size > quota {
+		// 配额不足以容纳完整的子分配器时，只申请剩余的配额
+		if size = quota - sma.size; size < need {
+			return nil, ErrQuotaExceeded
+		}
+	}
+	if child, err = sma.newChild(size); err != nil {
+		return
+	}
+	if quota := sma.config.quota; quota > 0 && sma.size+child.Size > quota {
+		// others grew sma while newChild waited for the arena
+		sma.dropChild(child)
+		return nil, ErrQuotaExceeded
+	}
 	sma.size += child.Size
-	memory = child.Malloc(size)
-	sma.children = append(sma.children, child)
+	sma.addChild(child)
 	return
 }
 
+// growChild doubles child in place, or more to fit need more bytes, up to
+// MaxBlockSize
+func (sma *ScalableMemoryAllocator) growChild(child *MemoryAllocator, need int) bool {
+	oldSize := child.Size
+	newSize := oldSize + max(oldSize, need)
+	if quota := sma.config.quota; quota > 0 && sma.size+newSize-oldSize > quota {
+		return false
+	}
+	if newSize > MaxBlockSize || !child.Grow(newSize) {
+		return false
+	}
+	sma.size += newSize - oldSize
+	return true
+}
+
 func (sma *ScalableMemoryAllocator) GetAllocator() *ScalableMemoryAllocator {
 	return sma
 }
@@ -331,24 +644,117 @@ func (sma *ScalableMemoryAllocator) FreeRest(mem *[]byte, keep int) {
 	}
 }
 
+// Realloc resizes mem, which must come from this allocator, to newSize bytes.
+// Shrinking frees the tail; growing extends mem in place when the memory right
+// after it is free, and otherwise allocates, copies and frees mem. It returns
+// nil when Malloc would, e.g. at the quota set with WithQuota; mem is then
+// still valid and still owned by the caller, who must free it.
+func (sma *ScalableMemoryAllocator) Realloc(mem []byte, newSize int) []byte {
+	if len(mem) == 0 {
+		return sma.Malloc(newSize)
+	}
+	if ret, ok := sma.resize(mem, newSize); ok {
+		return ret
+	}
+	ret := sma.Malloc(newSize)
+	if ret != nil {
+		copy(ret, mem)
+		sma.Free(mem)
+	}
+	return ret
+}
+
+// TryRealloc is like Realloc but reports why it failed, with the errors of
+// TryMalloc, in which case mem is left as it was.
+func (sma *ScalableMemoryAllocator) TryRealloc(mem []byte, newSize int) ([]byte, error) {
+	if len(mem) == 0 {
+		return sma.TryMalloc(newSize)
+	}
+	if ret, ok := sma.resize(mem, newSize); ok {
+		return ret, nil
+	}
+	ret, err := sma.TryMalloc(newSize)
+	if err != nil {
+		return nil, err
+	}
+	copy(ret, mem)
+	sma.Free(mem)
+	return ret, nil
+}
+
+// resize shrinks mem or extends it in place, and reports whether it did
+func (sma *ScalableMemoryAllocator) resize(mem []byte, newSize int) ([]byte, bool) {
+	oldSize := len(mem)
+	if newSize <= oldSize {
+		sma.FreeRest(&mem, newSize)
+		return mem, true
+	}
+	if sma != nil && newSize <= MaxBlockSize {
+		sma.mu.Lock()
+		defer sma.mu.Unlock()
+		if child, start := sma.findChild(int64(uintptr(unsafe.Pointer(&mem[0])))); child != nil {
+			if child.extend(start, oldSize, newSize) {
+				sma.addMallocCount(newSize - oldSize)
+				return child.memory[start : start+newSize], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// findChild returns the child owning ptr and the offset of ptr in that
+// child, or nil if no child owns it
+func (sma *ScalableMemoryAllocator) findChild(ptr int64) (child *MemoryAllocator, start int) {
+	// the child starting last at or before ptr
+	i, found := slices.BinarySearchFunc(sma.byStart, ptr, compareStart)
+	if !found {
+		i--
+	}
+	if i >= 0 {
+		child = sma.byStart[i]
+		if start = int(ptr - child.start); start < child.Size {
+			return
+		}
+	}
+	return nil, 0
+}
+
+// Free returns mem to the allocator and reports whether it succeeded.
+// Use TryFree to learn why it failed.
 func (sma *ScalableMemoryAllocator) Free(mem []byte) bool {
+	return sma.TryFree(mem) == nil
+}
+
+// TryFree returns mem to the allocator. It returns ErrNotOwned when mem does
+// not come from sma, and ErrDoubleFree or ErrOverlap when some of it is
+// already free, in which case nothing is freed.
+func (sma *ScalableMemoryAllocator) TryFree(mem []byte) error {
 	if sma == nil || len(mem) == 0 {
-		return false
+		return ErrNotOwned
 	}
 	sma.mu.Lock()
 	defer sma.mu.Unlock()
-	ptr := int64(uintptr(unsafe.Pointer(&mem[0])))
+	sma.drainPending()
+	return sma.free(mem)
+}
+
+func (sma *ScalableMemoryAllocator) free(mem []byte) error {
 	size := len(mem)
-	for i, child := range sma.children {
-		if start := int(ptr - child.start); start >= 0 && start < child.Size && child.free(start, size) {
-			sma.addFreeCount(size)
-			if len(sma.children) > 1 && child.allocator != nil && child.allocator.sizeTree.End-child.allocator.sizeTree.Start == child.Size {
-				child.Recycle()
-				sma.children = slices.Delete(sma.children, i, i+1)
-				sma.size -= child.Size
-			}
-			return true
-		}
+	child, start := sma.findChild(int64(uintptr(unsafe.Pointer(&mem[0]))))
+	if child == nil {
+		return ErrNotOwned
+	}
+	if err := child.free(start, size); err != nil {
+		return err
+	}
+	sma.addFreeCount(size)
+	if (len(sma.children) > 1 || child.Size > MaxBlockSize) && child.allocator != nil && child.allocator.IsEmpty() {
+		// unindex before recycling: once dropped, the same memory may become
+		// the child of another allocator, which must not find it still routed here
+		sma.children = slices.DeleteFunc(sma.children, func(c *MemoryAllocator) bool { return c == child })
+		sma.unindexChild(child)
+		sma.size -= child.Size
+		sma.dropChild(child)
 	}
-	return false
+	return nil
 }
@@ -1,6 +1,8 @@
 package gomem
 
 import (
+	"fmt"
+	"math/rand/v2"
 	"testing"
 )
 
@@ -279,6 +281,61 @@ func BenchmarkScalableMemoryAllocatorConcurrent(b *testing.B) {
 	}
 }
 
+// concurrentPattern runs the BenchmarkScalableMemoryAllocatorConcurrent
+// workload on every benchmark goroutine at once
+func concurrentPattern(b *testing.B, malloc func(int) []byte, free func([]byte) bool) {
+	b.RunParallel(func(pb *testing.PB) {
+		buffers := make([][]byte, 20)
+		for pb.Next() {
+			for j := range buffers {
+				if buffers[j] = malloc(64 * (j + 1)); buffers[j] == nil {
+					b.Error("Failed to allocate memory")
+					return
+				}
+			}
+			for _, mem := range buffers {
+				free(mem)
+			}
+		}
+	})
+}
+
+// BenchmarkScalableMemoryAllocatorConcurrentShared runs the Concurrent
+// workload on all goroutines against one allocator behind its lock
+func BenchmarkScalableMemoryAllocatorConcurrentShared(b *testing.B) {
+	allocator := NewScalableMemoryAllocator(1024)
+	b.ResetTimer()
+	concurrentPattern(b, allocator.Malloc, allocator.Free)
+}
+
+// BenchmarkShardedAllocatorConcurrent runs the same workload against a
+// ShardedAllocator with GOMAXPROCS shards
+func BenchmarkShardedAllocatorConcurrent(b *testing.B) {
+	allocator := NewShardedAllocator(0, 1024)
+	b.ResetTimer()
+	concurrentPattern(b, allocator.Malloc, allocator.Free)
+}
+
+// BenchmarkShardedAllocatorCrossFree allocates on one goroutine and frees on
+// another, as a reader handing frames to subscribers does
+func BenchmarkShardedAllocatorCrossFree(b *testing.B) {
+	allocator := NewShardedAllocator(0, 1024)
+	buffers := make(chan []byte, 1024)
+	done := make(chan struct{})
+	go func() {
+		for mem := range buffers {
+			allocator.Free(mem)
+		}
+		close(done)
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffers <- allocator.Malloc(1024)
+	}
+	close(buffers)
+	<-done
+}
+
 // BenchmarkScalableMemoryAllocatorMemoryPressure benchmarks under memory pressure
 func BenchmarkScalableMemoryAllocatorMemoryPressure(b *testing.B) {
 	allocator := NewScalableMemoryAllocator(1024)
@@ -307,3 +364,79 @@ func BenchmarkScalableMemoryAllocatorMemoryPressure(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkSlabAllocatorGetPut benchmarks fixed-size slots against
+// BenchmarkScalableMemoryAllocatorMallocFixed
+func BenchmarkSlabAllocatorGetPut(b *testing.B) {
+	slab := NewSlabAllocator(NewScalableMemoryAllocator(1<<20), 188, 64)
+	live := make([][]byte, 64)
+	for i := range live {
+		live[i] = slab.Get()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(live)
+		slab.Put(live[j])
+		if live[j] = slab.Get(); live[j] == nil {
+			b.Fatal("Failed to allocate memory")
+		}
+	}
+}
+
+// BenchmarkScalableMemoryAllocatorMallocFixed benchmarks the same workload
+// as BenchmarkSlabAllocatorGetPut on the general allocator
+func BenchmarkScalableMemoryAllocatorMallocFixed(b *testing.B) {
+	allocator := NewScalableMemoryAllocator(1 << 20)
+	live := make([][]byte, 64)
+	for i := range live {
+		live[i] = allocator.Malloc(188)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(live)
+		allocator.Free(live[j])
+		if live[j] = allocator.Malloc(188); live[j] == nil {
+			b.Fatal("Failed to allocate memory")
+		}
+	}
+}
+
+// BenchmarkScalableMemoryAllocatorFreeManyChildren benchmarks Free when the
+// owning child has to be found among many children
+func BenchmarkScalableMemoryAllocatorFreeManyChildren(b *testing.B) {
+	for _, n := range []int{64, 256} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			allocator := NewScalableMemoryAllocator(1 << 16)
+			allocator.mu.Lock()
+			for len(allocator.children) < n {
+				child, _ := allocator.newChild(1 << 16)
+				allocator.addChild(child)
+			}
+			allocator.mu.Unlock()
+			type block struct {
+				child *MemoryAllocator
+				mem   []byte
+			}
+			var blocks []block
+			for _, child := range allocator.children {
+				for range 8 {
+					blocks = append(blocks, block{child, child.Malloc(1 << 13)})
+				}
+			}
+			rand.New(rand.NewPCG(1, 2)).Shuffle(len(blocks), func(i, j int) {
+				blocks[i], blocks[j] = blocks[j], blocks[i]
+			})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				block := &blocks[i%len(blocks)]
+				if !allocator.Free(block.mem) {
+					b.Fatal("Failed to free memory")
+				}
+				// take the space back from the same child
+				block.mem = block.child.Malloc(1 << 13)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+//go:build !disable_rm
+
+package gomem
+
+import (
+	"cmp"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+type (
+	shardChild struct {
+		start int64
+		shard *ScalableMemoryAllocator
+	}
+	// ShardedAllocator spreads allocations over several
+	// ScalableMemoryAllocators so that goroutines rarely contend for the same
+	// lock. Malloc sticks to the shard last used on the same P, moving on
+	// to the next one whose lock is free when it is contended; Free finds the shard owning the memory by address, so memory may be
+	// freed from any goroutine.
+	ShardedAllocator struct {
+		shards []*ScalableMemoryAllocator
+		mu     sync.RWMutex // protects owners
+		owners []shardChild // children of all shards, sorted by start
+		// hints caches a shard index per P: sync.Pool keeps a local cache
+		// for each P, so Get mostly returns the index the P used last
+		hints sync.Pool
+	}
+)
+
+// NewShardedAllocator creates shards scalable allocators of size bytes with
+// the given options, GOMAXPROCS of them when shards is not positive.
+func NewShardedAllocator(shards, size int, opts ...ScalableOption) *ShardedAllocator {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	a := &ShardedAllocator{shards: make([]*ScalableMemoryAllocator, shards)}
+	var next atomic.Uint32
+	a.hints.New = func() any {
+		i := int(next.Add(1)-1) % shards
+		return &i
+	}
+	for i := range a.shards {
+		shard := NewScalableMemoryAllocator(size, opts...)
+		for _, child := range shard.children {
+			a.track(shard, child, true)
+		}
+		shard.onChild = func(child *MemoryAllocator, added bool) {
+			a.track(shard, child, added)
+		}
+		a.shards[i] = shard
+	}
+	return a
+}
+
+// track keeps owners in step with the children of shard, which is locked
+func (a *ShardedAllocator) track(shard *ScalableMemoryAllocator, child *MemoryAllocator, added bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i, found := slices.BinarySearchFunc(a.owners, child.start, compareShardChild)
+	if added {
+		a.owners = slices.Insert(a.owners, i, shardChild{child.start, shard})
+	} else if found {
+		a.owners = slices.Delete(a.owners, i, i+1)
+	}
+}
+
+func compareShardChild(c shardChild, start int64) int {
+	return cmp.Compare(c.start, start)
+}
+
+// Shards returns the scalable allocators behind a.
+func (a *ShardedAllocator) Shards() []*ScalableMemoryAllocator {
+	return a.shards
+}
+
+// lockShard returns a locked shard, preferring the one of the current P and
+// then any other that no goroutine holds
+func (a *ShardedAllocator) lockShard() *ScalableMemoryAllocator {
+	hint := a.hints.Get().(*int)
+	defer a.hints.Put(hint)
+	n := len(a.shards)
+	for i := range n {
+		if shard := a.shards[(*hint+i)%n]; shard.mu.TryLock() {
+			*hint = (*hint + i) % n
+			return shard
+		}
+	}
+	shard := a.shards[*hint]
+	shard.mu.Lock()
+	return shard
+}
+
+// Malloc allocates size bytes from one of the shards, like
+// ScalableMemoryAllocator.Malloc.
+func (a *ShardedAllocator) Malloc(size int) []byte {
+	return a.MallocAligned(size, 1)
+}
+
+// MallocAligned is like Malloc with the alignment of
+// ScalableMemoryAllocator.MallocAligned.
+func (a *ShardedAllocator) MallocAligned(size, align int) (memory []byte) {
+	shard := a.lockShard()
+	defer shard.mu.Unlock()
+	if memory, _ = shard.malloc(size, align); memory != nil {
+		shard.addMallocCount(size)
+	}
+	return
+}
+
+// Free returns mem to the shard owning it and reports whether it succeeded.
+// It may be called from any goroutine.
+func (a *ShardedAllocator) Free(mem []byte) bool {
+	return a.TryFree(mem) == nil
+}
+
+// TryFree is like ScalableMemoryAllocator.TryFree on the shard owning mem.
+func (a *ShardedAllocator) TryFree(mem []byte) error {
+	if len(mem) == 0 {
+		return ErrNotOwned
+	}
+	if shard := a.owner(int64(uintptr(unsafe.Pointer(&mem[0])))); shard != nil {
+		return shard.TryFree(mem)
+	}
+	return ErrNotOwned
+}
+
+// owner returns the shard of the child starting last at or before ptr. The
+// shard checks that the child really holds ptr.
+func (a *ShardedAllocator) owner(ptr int64) *ScalableMemoryAllocator {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	i, found := slices.BinarySearchFunc(a.owners, ptr, compareShardChild)
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	return a.owners[i].shard
+}
+
+// Stats merges the statistics of all shards.
+func (a *ShardedAllocator) Stats() (stats ScalableStats) {
+	for _, shard := range a.shards {
+		s := shard.Stats()
+		stats.TotalMalloc += s.TotalMalloc
+		stats.TotalFree += s.TotalFree
+		stats.InUse += s.InUse
+		stats.Reserved += s.Reserved
+		stats.Children += s.Children
+		stats.ChildrenCreated += s.ChildrenCreated
+		stats.ChildrenTrimmed += s.ChildrenTrimmed
+		stats.MallocFailures += s.MallocFailures
+		stats.Free.Merge(&s.Free)
+	}
+	return
+}
+
+// Recycle recycles every shard. Memory handed out before must not be used
+// afterwards.
+func (a *ShardedAllocator) Recycle() {
+	for _, shard := range a.shards {
+		shard.Recycle()
+	}
+}
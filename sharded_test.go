@@ -0,0 +1,115 @@
+package gomem
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestShardedAllocator(t *testing.T) {
+	a := NewShardedAllocator(4, 1<<12)
+	defer a.Recycle()
+	if len(a.Shards()) != 4 {
+		t.Fatalf("%d shards", len(a.Shards()))
+	}
+	// producers allocate, consumers on other goroutines free
+	buffers := make(chan []byte, 64)
+	var producers, consumers sync.WaitGroup
+	for p := range 8 {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			for i := range 2000 {
+				size := 1 + (p*131+i*17)%8192
+				mem := a.Malloc(size)
+				if len(mem) != size {
+					t.Errorf("malloc %d: got %d bytes", size, len(mem))
+					return
+				}
+				mem[0], mem[size-1] = byte(p), byte(p)
+				buffers <- mem
+			}
+		}()
+	}
+	for range 8 {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for mem := range buffers {
+				if mem[0] != mem[len(mem)-1] {
+					t.Error("buffer overwritten")
+				}
+				if err := a.TryFree(mem); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	producers.Wait()
+	close(buffers)
+	consumers.Wait()
+	stats := a.Stats()
+	if stats.InUse != 0 || stats.TotalMalloc != stats.TotalFree || stats.TotalMalloc == 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if err := a.TryFree(make([]byte, 16)); err != ErrNotOwned {
+		t.Fatalf("free foreign memory: %v", err)
+	}
+	mem := a.Malloc(100)
+	if !a.Free(mem) || a.Free(mem) {
+		t.Fatal("double free was not detected")
+	}
+}
+
+func TestShardedAllocatorChildChurn(t *testing.T) {
+	a := NewShardedAllocator(4, 1<<12)
+	defer a.Recycle()
+	// children are added and dropped all the time, while frees cross shards
+	buffers := make(chan [][]byte, 16)
+	var producers, consumers sync.WaitGroup
+	for p := range 8 {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			for i := range 500 {
+				batch := make([][]byte, 8)
+				for j := range batch {
+					batch[j] = a.Malloc(1<<10 + (p*257+i*31+j*4099)%(1<<15))
+				}
+				buffers <- batch
+			}
+		}()
+	}
+	for range 8 {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for batch := range buffers {
+				for _, mem := range batch {
+					if err := a.TryFree(mem); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	producers.Wait()
+	close(buffers)
+	consumers.Wait()
+	// every route must lead to a live child of its shard
+	children := 0
+	for _, shard := range a.Shards() {
+		children += len(shard.GetChildren())
+	}
+	if len(a.owners) != children {
+		t.Fatalf("%d routes for %d children", len(a.owners), children)
+	}
+	for _, owner := range a.owners {
+		if child, _ := owner.shard.findChild(owner.start); child == nil || child.start != owner.start {
+			t.Fatalf("stale route to %x", owner.start)
+		}
+	}
+	if stats := a.Stats(); stats.InUse != 0 || stats.ChildrenTrimmed == 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,189 @@
+package gomem
+
+import (
+	"math/bits"
+	"slices"
+	"sync"
+	"unsafe"
+)
+
+type (
+	slab struct {
+		memory     []byte
+		used       []uint64 // bitmap of the slots handed out
+		free       []int32  // stack of free slot indexes
+		prev, next *slab    // list of slabs with free slots
+	}
+	// SlabAllocator hands out equal-size slots carved from pages of a
+	// ScalableMemoryAllocator. Get and Put take constant time. Pages are
+	// aligned to their power-of-two size, so Put finds the page of a slot by
+	// masking its address.
+	SlabAllocator struct {
+		mu           sync.Mutex
+		parent       *ScalableMemoryAllocator
+		slotSize     int
+		slotsPerSlab int
+		pageSize     int
+		slabs        map[uintptr]*slab // by page address
+		partial      *slab             // head of the slabs with free slots
+	}
+)
+
+// NewSlabAllocator creates a slab allocator of slotSize-byte slots on top of
+// parent. Each page is sized to the power of two holding at least
+// slotsPerSlab slots and is filled with as many slots as fit. Pages are
+// capped at MaxBlockSize/2, the largest page whose aligned allocation stays
+// in an ordinary child rather than getting a mapping of its own, so a large
+// slotsPerSlab may get fewer slots per page. It panics when a slot does not
+// fit in such a page.
+func NewSlabAllocator(parent *ScalableMemoryAllocator, slotSize, slotsPerSlab int) *SlabAllocator {
+	if slotSize <= 0 || slotsPerSlab <= 0 {
+		panic("gomem: slab slot size and count must be positive")
+	}
+	if slotSize > MaxBlockSize/2 {
+		panic("gomem: slab slot larger than MaxBlockSize/2")
+	}
+	// MallocAligned reserves pageSize-1 bytes of padding: a larger page would
+	// go down the path of allocations over MaxBlockSize
+	pageSize := min(1<<bits.Len(uint(slotSize*slotsPerSlab-1)), MaxBlockSize/2)
+	return &SlabAllocator{
+		parent:       parent,
+		slotSize:     slotSize,
+		slotsPerSlab: pageSize / slotSize,
+		pageSize:     pageSize,
+		slabs:        make(map[uintptr]*slab),
+	}
+}
+
+// SlotSize returns the size of the slots handed out by Get.
+func (a *SlabAllocator) SlotSize() int {
+	return a.slotSize
+}
+
+// Get returns a free slot of SlotSize bytes, or nil when the parent
+// allocator cannot provide a new page. The slot's capacity is its length,
+// so appending to it never spills into the next slot.
+func (a *SlabAllocator) Get() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.partial
+	if s == nil {
+		if s = a.grow(); s == nil {
+			return nil
+		}
+	}
+	i := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	s.used[i/64] |= 1 << (i % 64)
+	if len(s.free) == 0 {
+		a.unlink(s)
+	}
+	offset := int(i) * a.slotSize
+	return s.memory[offset : offset+a.slotSize : offset+a.slotSize]
+}
+
+// Put returns a slot obtained from Get. It returns ErrNotOwned when mem is
+// not a slot of a, and ErrDoubleFree when the slot is already free. A slab
+// that becomes empty goes back to the parent allocator, unless it is the
+// last one.
+func (a *SlabAllocator) Put(mem []byte) error {
+	if len(mem) == 0 {
+		return ErrNotOwned
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+	page := addr &^ uintptr(a.pageSize-1)
+	s := a.slabs[page]
+	if s == nil || int(addr-page)%a.slotSize != 0 || int(addr-page)/a.slotSize >= a.slotsPerSlab {
+		return ErrNotOwned
+	}
+	i := int32(int(addr-page) / a.slotSize)
+	if s.used[i/64]&(1<<(i%64)) == 0 {
+		return ErrDoubleFree
+	}
+	s.used[i/64] &^= 1 << (i % 64)
+	s.free = append(s.free, i)
+	if len(s.free) == 1 {
+		a.link(s)
+	}
+	if len(s.free) == a.slotsPerSlab && len(a.slabs) > 1 {
+		a.unlink(s)
+		delete(a.slabs, page)
+		a.parent.Free(s.memory)
+	}
+	return nil
+}
+
+// Stats returns the usage of every slab, ordered by address.
+func (a *SlabAllocator) Stats() (stats SlabStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats.SlotSize = a.slotSize
+	stats.SlotsPerSlab = a.slotsPerSlab
+	pages := make([]uintptr, 0, len(a.slabs))
+	for page := range a.slabs {
+		pages = append(pages, page)
+	}
+	slices.Sort(pages)
+	for _, page := range pages {
+		s := a.slabs[page]
+		usage := SlabUsage{InUse: a.slotsPerSlab - len(s.free), Free: len(s.free)}
+		stats.InUse += usage.InUse
+		stats.Free += usage.Free
+		stats.Slabs = append(stats.Slabs, usage)
+	}
+	return
+}
+
+// Recycle returns every slab to the parent allocator. Slots handed out
+// before must not be used afterwards.
+func (a *SlabAllocator) Recycle() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.slabs {
+		a.parent.Free(s.memory)
+	}
+	clear(a.slabs)
+	a.partial = nil
+}
+
+// grow takes a new page from the parent and makes it the head of partial
+func (a *SlabAllocator) grow() *slab {
+	memory := a.parent.MallocAligned(a.pageSize, a.pageSize)
+	if memory == nil {
+		return nil
+	}
+	s := &slab{
+		memory: memory,
+		used:   make([]uint64, (a.slotsPerSlab+63)/64),
+		free:   make([]int32, a.slotsPerSlab),
+	}
+	// pop slots in address order
+	for i := range s.free {
+		s.free[i] = int32(a.slotsPerSlab - 1 - i)
+	}
+	a.slabs[uintptr(unsafe.Pointer(&memory[0]))] = s
+	a.link(s)
+	return s
+}
+
+func (a *SlabAllocator) link(s *slab) {
+	s.prev, s.next = nil, a.partial
+	if a.partial != nil {
+		a.partial.prev = s
+	}
+	a.partial = s
+}
+
+func (a *SlabAllocator) unlink(s *slab) {
+	if s.prev != nil {
+		s.prev.next = s.next
+	} else {
+		a.partial = s.next
+	}
+	if s.next != nil {
+		s.next.prev = s.prev
+	}
+	s.prev, s.next = nil, nil
+}
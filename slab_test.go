@@ -0,0 +1,92 @@
+package gomem
+
+import "testing"
+
+func TestSlabAllocator(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 16)
+	defer sma.Recycle()
+	slab := NewSlabAllocator(sma, 188, 16)
+	if stats := slab.Stats(); stats.SlotsPerSlab != 4096/188 {
+		t.Fatalf("slots per slab %d, want %d", stats.SlotsPerSlab, 4096/188)
+	}
+	var slots [][]byte
+	for i := range 100 {
+		slot := slab.Get()
+		if len(slot) != 188 || cap(slot) != 188 {
+			t.Fatalf("slot %d: len %d cap %d", i, len(slot), cap(slot))
+		}
+		slot[0], slot[187] = byte(i), byte(i)
+		slots = append(slots, slot)
+	}
+	stats := slab.Stats()
+	if stats.InUse != 100 || len(stats.Slabs) != 5 || stats.Free != 5*21-100 {
+		t.Fatalf("stats %+v", stats)
+	}
+	for i, slot := range slots {
+		if slot[0] != byte(i) || slot[187] != byte(i) {
+			t.Fatalf("slot %d overwritten", i)
+		}
+	}
+	if err := slab.Put(slots[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := slab.Put(slots[0]); err != ErrDoubleFree {
+		t.Fatalf("double put: %v", err)
+	}
+	if err := slab.Put(slots[1][1:]); err != ErrNotOwned {
+		t.Fatalf("misaligned put: %v", err)
+	}
+	if err := slab.Put(make([]byte, 188)); err != ErrNotOwned {
+		t.Fatalf("foreign put: %v", err)
+	}
+	// the freed slot is reused first
+	if slot := slab.Get(); &slot[0] != &slots[0][0] {
+		t.Fatal("freed slot not reused")
+	}
+	inUse := sma.Stats().InUse
+	for _, slot := range slots[:42] {
+		slab.Put(slot)
+	}
+	// the first two slabs are empty and went back to sma
+	if stats := slab.Stats(); len(stats.Slabs) != 3 || stats.InUse != 58 {
+		t.Fatalf("stats after put %+v", slab.Stats())
+	}
+	if got := sma.Stats().InUse; got != inUse-2*4096 {
+		t.Fatalf("parent in use %d, want %d", got, inUse-2*4096)
+	}
+	for _, slot := range slots[42:] {
+		slab.Put(slot)
+	}
+	// the last slab is kept
+	if stats := slab.Stats(); len(stats.Slabs) != 1 || stats.InUse != 0 {
+		t.Fatalf("stats after putting everything %+v", stats)
+	}
+	slab.Recycle()
+	if got := sma.Stats().InUse; got != 0 {
+		t.Fatalf("parent in use %d after Recycle", got)
+	}
+}
+
+func TestSlabAllocatorLargePages(t *testing.T) {
+	sma := NewScalableMemoryAllocator(1 << 16)
+	defer sma.Recycle()
+	slab := NewSlabAllocator(sma, 1<<12, 1<<12)
+	if stats := slab.Stats(); stats.SlotsPerSlab != MaxBlockSize/2>>12 {
+		t.Fatalf("slots per slab %d, want %d", stats.SlotsPerSlab, MaxBlockSize/2>>12)
+	}
+	if slab.Get() == nil {
+		t.Fatal("Get failed")
+	}
+	// the page comes from an ordinary child, not one mapped for it alone
+	for _, child := range sma.GetChildren() {
+		if child.Size > MaxBlockSize {
+			t.Fatalf("page in a child of %d bytes", child.Size)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a slot larger than MaxBlockSize/2 was accepted")
+		}
+	}()
+	NewSlabAllocator(sma, MaxBlockSize/2+1, 1)
+}
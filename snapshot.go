@@ -0,0 +1,211 @@
+package gomem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidSnapshot is returned when restoring a free space index from data
+// that is not a valid snapshot.
+var ErrInvalidSnapshot = errors.New("freespace: invalid snapshot")
+
+var snapshotMagic = []byte("GMFS\x01")
+
+// freeSpaceSnapshot is the YAML form of a free space index
+type freeSpaceSnapshot struct {
+	Size   int     `yaml:"size"`
+	Blocks []Block `yaml:"blocks"`
+}
+
+// marshalFreeSpace encodes size and the free blocks sorted by offset: the
+// magic, then uvarints for size, the block count, and for every block its
+// distance from the end of the previous one and its length
+func marshalFreeSpace(size int, blocks []*Block) []byte {
+	data := binary.AppendUvarint(append([]byte(nil), snapshotMagic...), uint64(size))
+	data = binary.AppendUvarint(data, uint64(len(blocks)))
+	end := 0
+	for _, b := range blocks {
+		data = binary.AppendUvarint(data, uint64(b.Start-end))
+		data = binary.AppendUvarint(data, uint64(b.End-b.Start))
+		end = b.End
+	}
+	return data
+}
+
+func unmarshalFreeSpace(data []byte) (snapshot freeSpaceSnapshot, err error) {
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		return snapshot, ErrInvalidSnapshot
+	}
+	data = data[len(snapshotMagic):]
+	next := func() int {
+		v, n := binary.Uvarint(data)
+		// bound every field so that start+length cannot overflow
+		if n <= 0 || v > math.MaxInt/4 {
+			err = ErrInvalidSnapshot
+			return 0
+		}
+		data = data[n:]
+		return int(v)
+	}
+	snapshot.Size = next()
+	count := next()
+	if err != nil || count > len(data) {
+		return snapshot, ErrInvalidSnapshot
+	}
+	snapshot.Blocks = make([]Block, count)
+	end := 0
+	for i := range snapshot.Blocks {
+		start := end + next()
+		end = start + next()
+		snapshot.Blocks[i] = Block{Start: start, End: end}
+	}
+	if err == nil && len(data) != 0 {
+		err = ErrInvalidSnapshot
+	}
+	if err == nil {
+		err = snapshot.check()
+	}
+	return
+}
+
+// check validates the blocks before they are inserted into an index
+func (s *freeSpaceSnapshot) check() error {
+	blocks := make([]*Block, len(s.Blocks))
+	for i := range s.Blocks {
+		blocks[i] = &s.Blocks[i]
+	}
+	if s.Size <= 0 || validateBlocks(blocks, s.Size) != nil {
+		return ErrInvalidSnapshot
+	}
+	return nil
+}
+
+func newFreeSpaceSnapshot(size int, blocks []*Block) freeSpaceSnapshot {
+	snapshot := freeSpaceSnapshot{Size: size, Blocks: make([]Block, len(blocks))}
+	for i, b := range blocks {
+		snapshot.Blocks[i] = *b
+	}
+	return snapshot
+}
+
+func unmarshalFreeSpaceYAML(value *yaml.Node) (snapshot freeSpaceSnapshot, err error) {
+	if err = value.Decode(&snapshot); err == nil {
+		err = snapshot.check()
+	}
+	return
+}
+
+// MarshalBinary encodes Size and the free blocks of the treap.
+func (a *Allocator) MarshalBinary() ([]byte, error) {
+	return marshalFreeSpace(a.Size, a.GetBlocks()), nil
+}
+
+// UnmarshalBinary rebuilds the treap from data written by the MarshalBinary
+// method of any FreeSpaceIndex implementation.
+func (a *Allocator) UnmarshalBinary(data []byte) error {
+	snapshot, err := unmarshalFreeSpace(data)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *Allocator) MarshalYAML() (any, error) {
+	return newFreeSpaceSnapshot(a.Size, a.GetBlocks()), nil
+}
+
+func (a *Allocator) UnmarshalYAML(value *yaml.Node) error {
+	snapshot, err := unmarshalFreeSpaceYAML(value)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *Allocator) restore(snapshot *freeSpaceSnapshot) {
+	a.sizeTree.Walk(a.putBlock)
+	a.sizeTree = nil
+	a.Size = snapshot.Size
+	a.cursor = 0
+	for _, b := range snapshot.Blocks {
+		a.insert(a.getBlock(b.Start, b.End))
+	}
+}
+
+// MarshalBinary encodes Size and the free blocks of the trees.
+func (a *TwoTreeAllocator) MarshalBinary() ([]byte, error) {
+	return marshalFreeSpace(a.Size, a.GetBlocks()), nil
+}
+
+// UnmarshalBinary rebuilds both trees from data written by the
+// MarshalBinary method of any FreeSpaceIndex implementation.
+func (a *TwoTreeAllocator) UnmarshalBinary(data []byte) error {
+	snapshot, err := unmarshalFreeSpace(data)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *TwoTreeAllocator) MarshalYAML() (any, error) {
+	return newFreeSpaceSnapshot(a.Size, a.GetBlocks()), nil
+}
+
+func (a *TwoTreeAllocator) UnmarshalYAML(value *yaml.Node) error {
+	snapshot, err := unmarshalFreeSpaceYAML(value)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *TwoTreeAllocator) restore(snapshot *freeSpaceSnapshot) {
+	a.Recycle()
+	a.Size = snapshot.Size
+	a.cursor = 0
+	for _, b := range snapshot.Blocks {
+		block := a.getBlock(b.Start, b.End)
+		a.insertSizeTree(block)
+		a.insertOffsetTree(block)
+	}
+}
+
+// MarshalBinary encodes Size and the free blocks of the index.
+func (a *TLSFAllocator) MarshalBinary() ([]byte, error) {
+	return marshalFreeSpace(a.Size, a.GetBlocks()), nil
+}
+
+// UnmarshalBinary rebuilds the index from data written by the MarshalBinary
+// method of any FreeSpaceIndex implementation.
+func (a *TLSFAllocator) UnmarshalBinary(data []byte) error {
+	snapshot, err := unmarshalFreeSpace(data)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *TLSFAllocator) MarshalYAML() (any, error) {
+	return newFreeSpaceSnapshot(a.Size, a.GetBlocks()), nil
+}
+
+func (a *TLSFAllocator) UnmarshalYAML(value *yaml.Node) error {
+	snapshot, err := unmarshalFreeSpaceYAML(value)
+	if err == nil {
+		a.restore(&snapshot)
+	}
+	return err
+}
+
+func (a *TLSFAllocator) restore(snapshot *freeSpaceSnapshot) {
+	a.reset()
+	a.Size = snapshot.Size
+	// the blocks are sorted by check, so each goes last
+	for _, b := range snapshot.Blocks {
+		a.addBlock(b.Start, b.End, nil)
+	}
+}
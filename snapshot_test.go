@@ -0,0 +1,84 @@
+package gomem
+
+import (
+	"encoding"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFreeSpaceIndexSnapshot(t *testing.T) {
+	type snapshotter interface {
+		FreeSpaceIndex
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	source := NewFreeSpaceIndex(StrategyTreap, 1<<16)
+	var used [][2]int
+	for range 500 {
+		if size := 1 + rng.IntN(1000); rng.IntN(3) > 0 {
+			if offset := source.Allocate(size); offset != -1 {
+				used = append(used, [2]int{offset, size})
+			}
+		} else if len(used) > 0 {
+			j := rng.IntN(len(used))
+			source.Free(used[j][0], used[j][1])
+			used = slices.Delete(used, j, j+1)
+		}
+	}
+	data, err := source.(snapshotter).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := yaml.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal := func(a, b []*Block) bool {
+		return slices.EqualFunc(a, b, func(x, y *Block) bool { return *x == *y })
+	}
+	for _, strategy := range strategies {
+		restored := NewFreeSpaceIndex(strategy, 100).(snapshotter)
+		restored.Allocate(10)
+		if err := restored.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
+		}
+		if !equal(restored.GetBlocks(), source.GetBlocks()) {
+			t.Fatalf("%v: restored blocks differ", strategy)
+		}
+		if err := restored.Validate(); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
+		}
+		fromYAML := NewFreeSpaceIndex(strategy, 100).(snapshotter)
+		if err := yaml.Unmarshal(text, fromYAML); err != nil {
+			t.Fatalf("%v: %v", strategy, err)
+		}
+		if !equal(fromYAML.GetBlocks(), source.GetBlocks()) {
+			t.Fatalf("%v: blocks restored from YAML differ", strategy)
+		}
+		// the restored index keeps working
+		for _, u := range used {
+			restored.Free(u[0], u[1])
+		}
+		if !restored.IsEmpty() {
+			t.Fatalf("%v: not empty after freeing everything", strategy)
+		}
+	}
+	bad := [][]byte{
+		nil,
+		data[:len(data)-1],
+		append(slices.Clone(data), 0),
+		[]byte("GMFS\x01\x0a\x02\x00\x05\x00\x05"), // blocks [0, 5) and [5, 10) not coalesced
+	}
+	for i, b := range bad {
+		if err := NewAllocator(10).UnmarshalBinary(b); err != ErrInvalidSnapshot {
+			t.Fatalf("bad snapshot %d: %v", i, err)
+		}
+	}
+	if err := yaml.Unmarshal([]byte("size: 10\nblocks: [{start: 5, end: 20}]"), NewAllocator(10)); err != ErrInvalidSnapshot {
+		t.Fatalf("block outside the arena: %v", err)
+	}
+}
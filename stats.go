@@ -0,0 +1,85 @@
+package gomem
+
+import "math/bits"
+
+// FreeHistogramBuckets is the number of power-of-two buckets in
+// FreeSpaceStats.Histogram.
+const FreeHistogramBuckets = 32
+
+type (
+	// FreeSpaceStats describes the free blocks of one or more arenas.
+	FreeSpaceStats struct {
+		FreeSize    int // total free bytes
+		FreeBlocks  int
+		LargestFree int
+		// Histogram[i] counts the free blocks whose size is in [2^i, 2^(i+1)),
+		// the last bucket also counts everything larger.
+		Histogram [FreeHistogramBuckets]int
+	}
+	// ScalableStats is a snapshot of a ScalableMemoryAllocator.
+	ScalableStats struct {
+		TotalMalloc int64
+		TotalFree   int64
+		InUse       int64 // TotalMalloc - TotalFree
+		Reserved    int   // total size of all children
+		Children    int
+		// ChildrenCreated and ChildrenTrimmed count the children added and
+		// released, by Trim or Free, over the allocator's life
+		ChildrenCreated int64
+		ChildrenTrimmed int64
+		MallocFailures  int64          // Malloc calls that got no memory
+		Free            FreeSpaceStats // merged over all children
+	}
+	// SlabUsage counts the slots of one slab.
+	SlabUsage struct {
+		InUse int
+		Free  int
+	}
+	// SlabStats is a snapshot of a SlabAllocator.
+	SlabStats struct {
+		SlotSize     int
+		SlotsPerSlab int
+		InUse        int // slots handed out over all slabs
+		Free         int
+		Slabs        []SlabUsage // ordered by address
+	}
+	// BuddyStats is a snapshot of a Buddy or FreeListBuddy pool.
+	BuddyStats struct {
+		PoolSize int
+		MinOrder int // log2 of the unit size
+		FreeSize int // total free bytes
+		// FreeBlocks[o] counts the free blocks of 1<<o units, that is of
+		// 1<<(o+MinOrder) bytes.
+		FreeBlocks []int
+		// LargestOrder is the largest order Alloc can serve without a Free,
+		// -1 when the pool is full.
+		LargestOrder int
+		Allocated    int // allocated blocks
+	}
+)
+
+// Fragmentation returns 1 - LargestFree/FreeSize: 0 when all free space is a
+// single block, approaching 1 as it is split into many small blocks.
+func (s *FreeSpaceStats) Fragmentation() float64 {
+	if s.FreeSize == 0 {
+		return 0
+	}
+	return 1 - float64(s.LargestFree)/float64(s.FreeSize)
+}
+
+func (s *FreeSpaceStats) addBlock(size int) {
+	s.FreeSize += size
+	s.FreeBlocks++
+	s.LargestFree = max(s.LargestFree, size)
+	s.Histogram[min(bits.Len(uint(size))-1, FreeHistogramBuckets-1)]++
+}
+
+// Merge adds the blocks counted in other to s.
+func (s *FreeSpaceStats) Merge(other *FreeSpaceStats) {
+	s.FreeSize += other.FreeSize
+	s.FreeBlocks += other.FreeBlocks
+	s.LargestFree = max(s.LargestFree, other.LargestFree)
+	for i, n := range other.Histogram {
+		s.Histogram[i] += n
+	}
+}
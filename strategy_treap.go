@@ -0,0 +1,7 @@
+//go:build !twotree
+
+package gomem
+
+// DefaultStrategy is the strategy used by allocators that don't choose one.
+// Build with the twotree tag to default to StrategyTwoTree instead.
+const DefaultStrategy = StrategyTreap
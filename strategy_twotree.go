@@ -0,0 +1,6 @@
+//go:build twotree
+
+package gomem
+
+// DefaultStrategy is the strategy used by allocators that don't choose one.
+const DefaultStrategy = StrategyTwoTree
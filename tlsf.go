@@ -0,0 +1,579 @@
+package gomem
+
+import (
+	"math/bits"
+)
+
+const (
+	tlsfSLI     = 4 // log2 of the number of second level lists
+	tlsfSLCount = 1 << tlsfSLI
+	tlsfFLCount = 64
+	tlsfRadix   = 4 // log2 of the fan-out of the end index
+	tlsfFanout  = 1 << tlsfRadix
+	tlsfDigit   = tlsfFanout - 1
+)
+
+type (
+	tlsfBlock struct {
+		Block
+		prevFree, nextFree *tlsfBlock // size class list, nextFree also links the pool
+		prevPhys, nextPhys *tlsfBlock // the free blocks before and after in address order
+	}
+	// tlsfNode is a node of the radix tree indexing the free blocks by End,
+	// one digit of tlsfRadix bits per level
+	tlsfNode struct {
+		used   uint16
+		kids   [tlsfFanout]*tlsfNode  // kids[0] also links the node pool
+		blocks [tlsfFanout]*tlsfBlock // in the last level
+	}
+	// TLSFAllocator is the two-level segregated fit implementation of
+	// FreeSpaceIndex (StrategyTLSF). Free blocks are kept in size-class lists
+	// found through two bitmaps, and linked to their free neighbours in
+	// address order. The free block around an offset is found through a radix
+	// tree keyed by block End, whose depth only depends on the arena size, so
+	// every operation but Stats and GetBlocks runs in bounded time whatever
+	// the number of free blocks.
+	TLSFAllocator struct {
+		pool        *tlsfBlock
+		nodePool    *tlsfNode
+		flBitmap    uint64
+		slBitmap    [tlsfFLCount]uint32
+		heads       [tlsfFLCount][tlsfSLCount]*tlsfBlock
+		first, last *tlsfBlock // free blocks in address order
+		ends        *tlsfNode  // root of the end index
+		endShift    int        // shift of the digit of the root
+		freeSize    int
+		Size        int
+	}
+)
+
+func NewTLSFAllocator(size int) (result *TLSFAllocator) {
+	result = &TLSFAllocator{}
+	result.Init(size)
+	return
+}
+
+// tlsfMapping returns the size class of size
+func tlsfMapping(size int) (fl, sl int) {
+	if size < tlsfSLCount {
+		return 0, size
+	}
+	f := bits.Len(uint(size)) - 1
+	return f - tlsfSLI + 1, size>>(f-tlsfSLI) - tlsfSLCount
+}
+
+// tlsfMappingSearch returns the first size class whose blocks are all at
+// least size bytes
+func tlsfMappingSearch(size int) (fl, sl int) {
+	if size >= tlsfSLCount {
+		size += 1<<(bits.Len(uint(size))-1-tlsfSLI) - 1
+	}
+	return tlsfMapping(size)
+}
+
+func (a *TLSFAllocator) insertFree(block *tlsfBlock) {
+	fl, sl := tlsfMapping(block.End - block.Start)
+	block.prevFree, block.nextFree = nil, a.heads[fl][sl]
+	if block.nextFree != nil {
+		block.nextFree.prevFree = block
+	}
+	a.heads[fl][sl] = block
+	a.flBitmap |= 1 << fl
+	a.slBitmap[fl] |= 1 << sl
+}
+
+func (a *TLSFAllocator) removeFree(block *tlsfBlock) {
+	fl, sl := tlsfMapping(block.End - block.Start)
+	if block.prevFree != nil {
+		block.prevFree.nextFree = block.nextFree
+	} else if a.heads[fl][sl] = block.nextFree; block.nextFree == nil {
+		if a.slBitmap[fl] &^= 1 << sl; a.slBitmap[fl] == 0 {
+			a.flBitmap &^= 1 << fl
+		}
+	}
+	if block.nextFree != nil {
+		block.nextFree.prevFree = block.prevFree
+	}
+	block.prevFree, block.nextFree = nil, nil
+}
+
+// find returns a free block of at least size bytes, or nil. Like any TLSF it
+// only looks at the head of the exact size class, so a fitting block further
+// down that list may be missed.
+func (a *TLSFAllocator) find(size int) *tlsfBlock {
+	fl, sl := tlsfMapping(size)
+	if fl >= tlsfFLCount {
+		return nil
+	}
+	// the head of the exact class is the closest fit when it is large enough
+	if block := a.heads[fl][sl]; block != nil && block.End-block.Start >= size {
+		return block
+	}
+	// every block of the classes from the search class on fits
+	sfl, ssl := tlsfMappingSearch(size)
+	if sfl >= tlsfFLCount {
+		return nil
+	}
+	slMap := a.slBitmap[sfl] & (^uint32(0) << ssl)
+	if slMap == 0 {
+		flMap := a.flBitmap & (^uint64(0) << (sfl + 1))
+		if sfl+1 >= tlsfFLCount || flMap == 0 {
+			return nil
+		}
+		sfl = bits.TrailingZeros64(flMap)
+		slMap = a.slBitmap[sfl]
+	}
+	return a.heads[sfl][bits.TrailingZeros32(slMap)]
+}
+
+// findAligned returns the first head of the size classes from that of size
+// on whose block holds size bytes at an offset aligned to align, or nil
+func (a *TLSFAllocator) findAligned(size, align int) *tlsfBlock {
+	fl, sl := tlsfMapping(size)
+	for flMap := a.flBitmap &^ (1<<fl - 1); flMap != 0; flMap &= flMap - 1 {
+		f := bits.TrailingZeros64(flMap)
+		slMap := a.slBitmap[f]
+		if f == fl {
+			slMap &= ^uint32(0) << sl
+		}
+		for ; slMap != 0; slMap &= slMap - 1 {
+			if b := a.heads[f][bits.TrailingZeros32(slMap)]; alignUp(b.Start, align)+size <= b.End {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func (a *TLSFAllocator) getBlock(start, end int) *tlsfBlock {
+	if a.pool == nil {
+		return &tlsfBlock{Block: Block{Start: start, End: end}}
+	}
+	block := a.pool
+	a.pool = block.nextFree
+	block.nextFree = nil
+	block.Start, block.End = start, end
+	return block
+}
+
+func (a *TLSFAllocator) putBlock(block *tlsfBlock) {
+	block.prevFree, block.prevPhys, block.nextPhys = nil, nil, nil
+	block.nextFree = a.pool
+	a.pool = block
+}
+
+func (a *TLSFAllocator) getNode() *tlsfNode {
+	if a.nodePool == nil {
+		return &tlsfNode{}
+	}
+	node := a.nodePool
+	a.nodePool = node.kids[0]
+	node.kids[0] = nil
+	return node
+}
+
+// putNode pools an empty node
+func (a *TLSFAllocator) putNode(node *tlsfNode) {
+	node.kids[0] = a.nodePool
+	a.nodePool = node
+}
+
+// putNodes pools node and every node below it
+func (a *TLSFAllocator) putNodes(node *tlsfNode, shift int) {
+	for used := node.used; used != 0; used &= used - 1 {
+		if i := bits.TrailingZeros16(used); shift > 0 {
+			a.putNodes(node.kids[i], shift-tlsfRadix)
+		}
+	}
+	*node = tlsfNode{}
+	a.putNode(node)
+}
+
+// indexEnd adds block to the end index, adding a level on top when its End
+// is too large for the tree
+func (a *TLSFAllocator) indexEnd(block *tlsfBlock) {
+	end := block.End
+	for end>>a.endShift > tlsfDigit {
+		root := a.getNode()
+		if a.ends.used != 0 {
+			root.kids[0], root.used = a.ends, 1
+		} else {
+			a.putNode(a.ends)
+		}
+		a.ends = root
+		a.endShift += tlsfRadix
+	}
+	node := a.ends
+	for shift := a.endShift; shift > 0; shift -= tlsfRadix {
+		i := end >> shift & tlsfDigit
+		if node.used&(1<<i) == 0 {
+			node.kids[i] = a.getNode()
+			node.used |= 1 << i
+		}
+		node = node.kids[i]
+	}
+	node.blocks[end&tlsfDigit] = block
+	node.used |= 1 << (end & tlsfDigit)
+}
+
+// unindexEnd removes the block ending at end from the end index and pools
+// the nodes left empty
+func (a *TLSFAllocator) unindexEnd(end int) {
+	var path [64 / tlsfRadix]*tlsfNode
+	depth, node := 0, a.ends
+	for shift := a.endShift; shift > 0; shift -= tlsfRadix {
+		path[depth] = node
+		depth++
+		node = node.kids[end>>shift&tlsfDigit]
+	}
+	node.blocks[end&tlsfDigit] = nil
+	node.used &^= 1 << (end & tlsfDigit)
+	for shift := tlsfRadix; node.used == 0 && depth > 0; shift += tlsfRadix {
+		depth--
+		parent := path[depth]
+		i := end >> shift & tlsfDigit
+		parent.kids[i] = nil
+		parent.used &^= 1 << i
+		a.putNode(node)
+		node = parent
+	}
+}
+
+// endAtLeast returns the free block with the smallest End not below end, or
+// nil. The free block holding an offset is endAtLeast(offset+1) when its
+// Start is not after offset.
+func (a *TLSFAllocator) endAtLeast(end int) *tlsfBlock {
+	end = max(end, 0)
+	if end>>a.endShift > tlsfDigit {
+		return nil
+	}
+	return a.ends.ceil(end, a.endShift)
+}
+
+// ceil returns the block with the smallest key not below key in the subtree
+// of node, whose digit is at shift
+func (node *tlsfNode) ceil(key, shift int) *tlsfBlock {
+	i := key >> shift & tlsfDigit
+	if shift == 0 {
+		if m := node.used >> i; m != 0 {
+			return node.blocks[i+bits.TrailingZeros16(m)]
+		}
+		return nil
+	}
+	if node.used&(1<<i) != 0 {
+		if block := node.kids[i].ceil(key, shift-tlsfRadix); block != nil {
+			return block
+		}
+	}
+	// every key of a later kid is larger
+	if m := node.used >> i >> 1; m != 0 {
+		node = node.kids[i+1+bits.TrailingZeros16(m)]
+		for shift -= tlsfRadix; shift > 0; shift -= tlsfRadix {
+			node = node.kids[bits.TrailingZeros16(node.used)]
+		}
+		return node.blocks[bits.TrailingZeros16(node.used)]
+	}
+	return nil
+}
+
+// count returns the number of blocks in the subtree of node
+func (node *tlsfNode) count(shift int) (n int) {
+	if shift == 0 {
+		return bits.OnesCount16(node.used)
+	}
+	for used := node.used; used != 0; used &= used - 1 {
+		n += node.kids[bits.TrailingZeros16(used)].count(shift - tlsfRadix)
+	}
+	return
+}
+
+// addBlock indexes a new free block [start, end) placed before next in
+// address order, or last when next is nil
+func (a *TLSFAllocator) addBlock(start, end int, next *tlsfBlock) {
+	block := a.getBlock(start, end)
+	prev := a.last
+	if next != nil {
+		prev, next.prevPhys = next.prevPhys, block
+	} else {
+		a.last = block
+	}
+	if block.prevPhys, block.nextPhys = prev, next; prev != nil {
+		prev.nextPhys = block
+	} else {
+		a.first = block
+	}
+	a.indexEnd(block)
+	a.insertFree(block)
+	a.freeSize += end - start
+}
+
+// dropBlock removes a free block from the index and recycles it
+func (a *TLSFAllocator) dropBlock(block *tlsfBlock) {
+	a.removeFree(block)
+	a.unindexEnd(block.End)
+	if block.prevPhys != nil {
+		block.prevPhys.nextPhys = block.nextPhys
+	} else {
+		a.first = block.nextPhys
+	}
+	if block.nextPhys != nil {
+		block.nextPhys.prevPhys = block.prevPhys
+	} else {
+		a.last = block.prevPhys
+	}
+	a.freeSize -= block.End - block.Start
+	a.putBlock(block)
+}
+
+// setStart moves the Start of a free block, keeping it indexed
+func (a *TLSFAllocator) setStart(block *tlsfBlock, start int) {
+	a.removeFree(block)
+	a.freeSize += block.Start - start
+	block.Start = start
+	a.insertFree(block)
+}
+
+// setEnd moves the End of a free block, keeping it indexed
+func (a *TLSFAllocator) setEnd(block *tlsfBlock, end int) {
+	a.removeFree(block)
+	a.unindexEnd(block.End)
+	a.freeSize += end - block.End
+	block.End = end
+	a.indexEnd(block)
+	a.insertFree(block)
+}
+
+// takeHead removes the first size bytes of a free block
+func (a *TLSFAllocator) takeHead(block *tlsfBlock, size int) {
+	if block.End-block.Start == size {
+		a.dropBlock(block)
+	} else {
+		a.setStart(block, block.Start+size)
+	}
+}
+
+func (a *TLSFAllocator) Allocate(size int) (offset int) {
+	if debugValidate {
+		defer mustValidate(a, "Allocate")
+	}
+	block := a.find(size)
+	if block == nil {
+		return -1
+	}
+	offset = block.Start
+	a.takeHead(block, size)
+	return
+}
+
+// AllocateAligned allocates size bytes at an offset that is a multiple of
+// align. The padding in front of the block stays free.
+func (a *TLSFAllocator) AllocateAligned(size, align int) (offset int) {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAligned")
+	}
+	if align <= 1 {
+		return a.Allocate(size)
+	}
+	block := a.find(size + align - 1)
+	if block == nil {
+		// a smaller block may happen to be aligned well enough
+		if block = a.findAligned(size, align); block == nil {
+			return -1
+		}
+	}
+	offset = alignUp(block.Start, align)
+	a.take(block, offset, size)
+	return
+}
+
+// AllocateAt allocates exactly [offset, offset+size). It returns
+// ErrOutOfRange when the range is not inside the arena and ErrAllocated,
+// changing nothing, when any byte of it is already allocated.
+func (a *TLSFAllocator) AllocateAt(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "AllocateAt")
+	}
+	if size <= 0 || offset < 0 || offset+size > a.Size {
+		return ErrOutOfRange
+	}
+	block := a.endAtLeast(offset + 1)
+	if block == nil || block.Start > offset || block.End < offset+size {
+		return ErrAllocated
+	}
+	a.take(block, offset, size)
+	return nil
+}
+
+// take allocates [offset, offset+size) from block, which contains it, and
+// keeps the free remainders on either side
+func (a *TLSFAllocator) take(block *tlsfBlock, offset, size int) {
+	switch tail := offset + size; {
+	case offset == block.Start:
+		a.takeHead(block, size)
+	case tail == block.End:
+		a.setEnd(block, offset)
+	default:
+		// the head becomes a block of its own, block keeps its End
+		a.addBlock(block.Start, offset, block)
+		a.setStart(block, tail)
+	}
+}
+
+// Free returns the range to the index and coalesces it with the free blocks
+// on either side, found as the first free block ending after offset and the
+// one before it in address order. Since those are the only free blocks the
+// range can overlap, any overlap is reported: ErrDoubleFree when the range is
+// entirely free, ErrOverlap otherwise, changing nothing.
+func (a *TLSFAllocator) Free(offset, size int) error {
+	if debugValidate {
+		defer mustValidate(a, "Free")
+	}
+	next := a.endAtLeast(offset + 1)
+	if next != nil && next.Start <= offset {
+		// offset is inside a free block
+		return checkFree(next.End, offset+size, offset, size)
+	}
+	prev := a.last
+	if next != nil {
+		if next.Start < offset+size {
+			return ErrOverlap
+		}
+		prev = next.prevPhys
+	}
+	joinPrev := prev != nil && prev.End == offset
+	joinNext := next != nil && next.Start == offset+size
+	switch {
+	case joinPrev && joinNext:
+		start := prev.Start
+		a.dropBlock(prev)
+		a.setStart(next, start)
+	case joinPrev:
+		a.setEnd(prev, offset+size)
+	case joinNext:
+		a.setStart(next, offset)
+	default:
+		a.addBlock(offset, offset+size, next)
+	}
+	return nil
+}
+
+// Extend grows the allocation [offset, offset+oldSize) to newSize bytes in
+// place by taking the head of the adjacent free block on its right.
+// It reports false and changes nothing when that block is missing or too small.
+func (a *TLSFAllocator) Extend(offset, oldSize, newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Extend")
+	}
+	if newSize <= oldSize {
+		return newSize == oldSize
+	}
+	grow := newSize - oldSize
+	right := a.endAtLeast(offset + oldSize + 1)
+	if right == nil || right.Start != offset+oldSize || right.End-right.Start < grow {
+		return false
+	}
+	a.takeHead(right, grow)
+	return true
+}
+
+func (a *TLSFAllocator) Grow(newSize int) {
+	if debugValidate {
+		defer mustValidate(a, "Grow")
+	}
+	if newSize <= a.Size {
+		return
+	}
+	if tail := a.last; tail != nil && tail.End == a.Size {
+		a.setEnd(tail, newSize)
+	} else {
+		a.addBlock(a.Size, newSize, nil)
+	}
+	a.Size = newSize
+}
+
+func (a *TLSFAllocator) Shrink(newSize int) bool {
+	if debugValidate {
+		defer mustValidate(a, "Shrink")
+	}
+	if newSize <= 0 || newSize >= a.Size {
+		return newSize == a.Size
+	}
+	tail := a.last
+	if tail == nil || tail.End != a.Size || tail.Start > newSize {
+		return false
+	}
+	if tail.Start == newSize {
+		a.dropBlock(tail)
+	} else {
+		a.setEnd(tail, newSize)
+	}
+	a.Size = newSize
+	return true
+}
+
+func (a *TLSFAllocator) Find(size int) (offset int) {
+	block := a.find(size)
+	if block == nil {
+		return -1
+	}
+	return block.Start
+}
+
+func (a *TLSFAllocator) GetFreeSize() int {
+	return a.freeSize
+}
+
+func (a *TLSFAllocator) Stats() (stats FreeSpaceStats) {
+	for block := a.first; block != nil; block = block.nextPhys {
+		stats.addBlock(block.End - block.Start)
+	}
+	return
+}
+
+func (a *TLSFAllocator) GetBlocks() (blocks []*Block) {
+	for block := a.first; block != nil; block = block.nextPhys {
+		blocks = append(blocks, &block.Block)
+	}
+	return
+}
+
+func (a *TLSFAllocator) IsEmpty() bool {
+	return a.first != nil && a.first.Start == 0 && a.first.End == a.Size
+}
+
+func (a *TLSFAllocator) Init(size int) {
+	a.reset()
+	a.Size = size
+	a.addBlock(0, size, nil)
+}
+
+func (a *TLSFAllocator) Recycle() {
+	a.reset()
+	a.pool, a.nodePool = nil, nil
+}
+
+// reset forgets every free block, keeping their nodes in the pools
+func (a *TLSFAllocator) reset() {
+	for block := a.first; block != nil; {
+		next := block.nextPhys
+		a.putBlock(block)
+		block = next
+	}
+	a.first, a.last = nil, nil
+	if a.ends != nil {
+		a.putNodes(a.ends, a.endShift)
+	}
+	a.ends, a.endShift = a.getNode(), 0
+	clear(a.heads[:])
+	clear(a.slBitmap[:])
+	a.flBitmap = 0
+	a.freeSize = 0
+}
+
+func (a *TLSFAllocator) Strategy() AllocatorStrategy {
+	return StrategyTLSF
+}
+
+// SetFitPolicy does nothing: TLSF always uses its segregated good fit.
+func (a *TLSFAllocator) SetFitPolicy(policy FitPolicy) {
+}
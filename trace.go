@@ -0,0 +1,302 @@
+package gomem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TraceOp is the kind of a recorded allocator call.
+type TraceOp byte
+
+const (
+	// TraceInit starts a new arena of Size bytes for Child.
+	TraceInit TraceOp = iota
+	// TraceMalloc is an Allocate of Size bytes that returned Offset.
+	TraceMalloc
+	// TraceMallocAligned is an AllocateAligned of Size bytes aligned to Arg
+	// that returned Offset.
+	TraceMallocAligned
+	// TraceFree is a Free of Size bytes at Offset.
+	TraceFree
+	// TraceBorrow is a Find of Size bytes, as done by Borrow, that returned
+	// Offset.
+	TraceBorrow
+	// TraceExtend is a successful Extend of the Size bytes at Offset to Arg
+	// bytes.
+	TraceExtend
+	// TraceRecycle drops the arena of Child.
+	TraceRecycle
+	// TracePolicy switches the arena of Child to the FitPolicy in Arg.
+	TracePolicy
+	// TraceAllocateAt is a successful AllocateAt of Size bytes at Offset.
+	TraceAllocateAt
+	// TraceGrow grows the arena of Child to Size bytes.
+	TraceGrow
+	// TraceShrink is a successful Shrink of the arena of Child to Size bytes.
+	TraceShrink
+)
+
+// TraceFormat selects the encoding written by a TraceRecorder.
+type TraceFormat int
+
+const (
+	// TraceBinary is a compact varint encoding.
+	TraceBinary TraceFormat = iota
+	// TraceYAML writes one YAML list item per event, so the file is a valid
+	// YAML sequence at any point.
+	TraceYAML
+)
+
+var traceOpNames = [...]string{"init", "malloc", "malloc_aligned", "free", "borrow", "extend", "recycle", "policy", "allocate_at", "grow", "shrink"}
+
+var (
+	traceMagic = []byte("GMTR\x01")
+	// ErrInvalidTrace is returned when a trace can't be decoded.
+	ErrInvalidTrace = errors.New("trace: invalid data")
+)
+
+type (
+	// TraceEvent is one recorded allocator call.
+	TraceEvent struct {
+		Op     TraceOp `yaml:"op"`
+		Child  int     `yaml:"child"`
+		Offset int     `yaml:"offset"`
+		Size   int     `yaml:"size"`
+		Arg    int     `yaml:"arg,omitempty"`
+		Time   int64   `yaml:"time"` // nanoseconds since the recorder was created
+	}
+	// TraceRecorder streams allocator events to an io.Writer.
+	// It is safe to share one recorder between several allocators.
+	TraceRecorder struct {
+		mu       sync.Mutex
+		w        *bufio.Writer
+		format   TraceFormat
+		start    time.Time
+		last     int64
+		children int
+		buf      []byte
+		err      error
+	}
+	// tracedIndex is a FreeSpaceIndex that reports every call to a recorder.
+	tracedIndex struct {
+		FreeSpaceIndex
+		recorder *TraceRecorder
+		child    int
+	}
+)
+
+func (op TraceOp) String() string {
+	if int(op) < len(traceOpNames) {
+		return traceOpNames[op]
+	}
+	return fmt.Sprintf("TraceOp(%d)", op)
+}
+
+func (op TraceOp) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+func (op *TraceOp) UnmarshalText(text []byte) error {
+	for i, name := range traceOpNames {
+		if name == string(text) {
+			*op = TraceOp(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown op %q", ErrInvalidTrace, text)
+}
+
+// NewTraceRecorder creates a recorder writing events to w in the given format.
+// Call Close to flush buffered events.
+func NewTraceRecorder(w io.Writer, format TraceFormat) *TraceRecorder {
+	r := &TraceRecorder{w: bufio.NewWriter(w), format: format, start: time.Now()}
+	if format == TraceBinary {
+		_, r.err = r.w.Write(traceMagic)
+	}
+	return r
+}
+
+// Trace attaches the recorder to a fresh index of size bytes and returns the
+// index to use instead of it. Every child traced gets its own Child number.
+func (r *TraceRecorder) Trace(index FreeSpaceIndex, size int) FreeSpaceIndex {
+	r.mu.Lock()
+	child := r.children
+	r.children++
+	r.mu.Unlock()
+	r.record(TraceInit, child, 0, size, 0)
+	return &tracedIndex{FreeSpaceIndex: index, recorder: r, child: child}
+}
+
+func (r *TraceRecorder) record(op TraceOp, child, offset, size, arg int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	now := int64(time.Since(r.start))
+	switch r.format {
+	case TraceBinary:
+		r.buf = append(r.buf[:0], byte(op))
+		r.buf = binary.AppendUvarint(r.buf, uint64(child))
+		r.buf = binary.AppendVarint(r.buf, int64(offset))
+		r.buf = binary.AppendUvarint(r.buf, uint64(size))
+		r.buf = binary.AppendUvarint(r.buf, uint64(arg))
+		r.buf = binary.AppendUvarint(r.buf, uint64(now-r.last))
+		_, r.err = r.w.Write(r.buf)
+	case TraceYAML:
+		var out []byte
+		if out, r.err = yaml.Marshal([]TraceEvent{{Op: op, Child: child, Offset: offset, Size: size, Arg: arg, Time: now}}); r.err == nil {
+			_, r.err = r.w.Write(out)
+		}
+	}
+	r.last = now
+}
+
+// Flush writes buffered events to the underlying writer.
+func (r *TraceRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// Close flushes the recorder and returns the first error it met.
+// Events recorded after Close are dropped.
+func (r *TraceRecorder) Close() error {
+	err := r.Flush()
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+	r.mu.Unlock()
+	return err
+}
+
+// ReadTrace decodes a trace written by a TraceRecorder in either format.
+func ReadTrace(reader io.Reader) (events []TraceEvent, err error) {
+	br := bufio.NewReader(reader)
+	if head, _ := br.Peek(len(traceMagic)); !bytes.Equal(head, traceMagic) {
+		if err = yaml.NewDecoder(br).Decode(&events); err == io.EOF {
+			err = nil
+		}
+		return
+	}
+	br.Discard(len(traceMagic))
+	var now int64
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		var fields [5]uint64
+		for i := range fields {
+			if i == 1 {
+				var offset int64
+				offset, err = binary.ReadVarint(br)
+				fields[i] = uint64(offset)
+			} else {
+				fields[i], err = binary.ReadUvarint(br)
+			}
+			if err != nil {
+				return events, ErrInvalidTrace
+			}
+		}
+		now += int64(fields[4])
+		events = append(events, TraceEvent{
+			Op:     TraceOp(op),
+			Child:  int(fields[0]),
+			Offset: int(int64(fields[1])),
+			Size:   int(fields[2]),
+			Arg:    int(fields[3]),
+			Time:   now,
+		})
+	}
+}
+
+func (t *tracedIndex) Allocate(size int) (offset int) {
+	offset = t.FreeSpaceIndex.Allocate(size)
+	t.recorder.record(TraceMalloc, t.child, offset, size, 0)
+	return
+}
+
+func (t *tracedIndex) AllocateAligned(size, align int) (offset int) {
+	offset = t.FreeSpaceIndex.AllocateAligned(size, align)
+	t.recorder.record(TraceMallocAligned, t.child, offset, size, align)
+	return
+}
+
+func (t *tracedIndex) AllocateAt(offset, size int) (err error) {
+	if err = t.FreeSpaceIndex.AllocateAt(offset, size); err == nil {
+		t.recorder.record(TraceAllocateAt, t.child, offset, size, 0)
+	}
+	return
+}
+
+func (t *tracedIndex) Free(offset, size int) (err error) {
+	if err = t.FreeSpaceIndex.Free(offset, size); err == nil {
+		t.recorder.record(TraceFree, t.child, offset, size, 0)
+	}
+	return
+}
+
+func (t *tracedIndex) Extend(offset, oldSize, newSize int) (ok bool) {
+	if ok = t.FreeSpaceIndex.Extend(offset, oldSize, newSize); ok {
+		t.recorder.record(TraceExtend, t.child, offset, oldSize, newSize)
+	}
+	return
+}
+
+func (t *tracedIndex) Find(size int) (offset int) {
+	offset = t.FreeSpaceIndex.Find(size)
+	t.recorder.record(TraceBorrow, t.child, offset, size, 0)
+	return
+}
+
+func (t *tracedIndex) Grow(newSize int) {
+	t.FreeSpaceIndex.Grow(newSize)
+	t.recorder.record(TraceGrow, t.child, 0, newSize, 0)
+}
+
+func (t *tracedIndex) Shrink(newSize int) (ok bool) {
+	if ok = t.FreeSpaceIndex.Shrink(newSize); ok {
+		t.recorder.record(TraceShrink, t.child, 0, newSize, 0)
+	}
+	return
+}
+
+func (t *tracedIndex) Init(size int) {
+	t.FreeSpaceIndex.Init(size)
+	t.recorder.record(TraceInit, t.child, 0, size, 0)
+}
+
+func (t *tracedIndex) SetFitPolicy(policy FitPolicy) {
+	t.FreeSpaceIndex.SetFitPolicy(policy)
+	t.recorder.record(TracePolicy, t.child, 0, 0, int(policy))
+}
+
+func (t *tracedIndex) Recycle() {
+	t.FreeSpaceIndex.Recycle()
+	t.recorder.record(TraceRecycle, t.child, 0, 0, 0)
+}
+
+// untrace returns the index wrapped by a recorder, recording that it leaves
+// the trace, or index itself if it isn't traced
+func untrace(index FreeSpaceIndex) FreeSpaceIndex {
+	if t, ok := index.(*tracedIndex); ok {
+		t.recorder.record(TraceRecycle, t.child, 0, 0, 0)
+		return t.FreeSpaceIndex
+	}
+	return index
+}
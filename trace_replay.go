@@ -0,0 +1,102 @@
+package gomem
+
+type (
+	// TraceDivergence is a replayed event whose result differs from the
+	// recorded one.
+	TraceDivergence struct {
+		Index int // position of the event in the trace
+		Event TraceEvent
+		Got   int // offset returned by the replay
+	}
+	// ReplayReport summarizes the replay of a trace.
+	ReplayReport struct {
+		Strategy    AllocatorStrategy
+		Events      int
+		Divergences []TraceDivergence
+		InUse       int // bytes still allocated at the end of the trace
+		PeakInUse   int
+		Reserved    int            // total size of the arenas alive at the end
+		Free        FreeSpaceStats // free blocks at the end of the trace
+	}
+)
+
+// ReplayTrace replays events against fresh indexes of the given strategy.
+// Frees use the recorded offsets, so replay keeps going after a divergence.
+func ReplayTrace(events []TraceEvent, strategy AllocatorStrategy) (report ReplayReport) {
+	type arena struct {
+		index       FreeSpaceIndex
+		size, inUse int
+	}
+	arenas := make(map[int]*arena)
+	report.Strategy = strategy
+	report.Events = len(events)
+	for i, e := range events {
+		a := arenas[e.Child]
+		if a == nil && e.Op != TraceInit {
+			// the child was never initialized in this trace
+			report.Divergences = append(report.Divergences, TraceDivergence{Index: i, Event: e, Got: -1})
+			continue
+		}
+		got := e.Offset
+		switch e.Op {
+		case TraceInit:
+			if a == nil {
+				a = &arena{index: NewFreeSpaceIndex(strategy, e.Size)}
+				arenas[e.Child] = a
+			}
+			a.index.Init(e.Size)
+			report.InUse -= a.inUse
+			a.size, a.inUse = e.Size, 0
+		case TraceMalloc:
+			got = a.index.Allocate(e.Size)
+		case TraceMallocAligned:
+			got = a.index.AllocateAligned(e.Size, e.Arg)
+		case TraceAllocateAt:
+			if a.index.AllocateAt(e.Offset, e.Size) != nil {
+				got = -1
+			}
+		case TraceGrow:
+			a.index.Grow(e.Size)
+			a.size = max(a.size, e.Size)
+		case TraceShrink:
+			if !a.index.Shrink(e.Size) {
+				got = -1
+			} else {
+				a.size = e.Size
+			}
+		case TraceBorrow:
+			got = a.index.Find(e.Size)
+		case TraceExtend:
+			if !a.index.Extend(e.Offset, e.Size, e.Arg) {
+				got = -1
+			}
+			a.inUse += e.Arg - e.Size
+			report.InUse += e.Arg - e.Size
+		case TraceFree:
+			if a.index.Free(e.Offset, e.Size) != nil {
+				got = -1
+			}
+			a.inUse -= e.Size
+			report.InUse -= e.Size
+		case TracePolicy:
+			a.index.SetFitPolicy(FitPolicy(e.Arg))
+		case TraceRecycle:
+			report.InUse -= a.inUse
+			delete(arenas, e.Child)
+		}
+		if got != e.Offset {
+			report.Divergences = append(report.Divergences, TraceDivergence{Index: i, Event: e, Got: got})
+		}
+		if (e.Op == TraceMalloc || e.Op == TraceMallocAligned || e.Op == TraceAllocateAt) && e.Offset != -1 {
+			a.inUse += e.Size
+			report.InUse += e.Size
+		}
+		report.PeakInUse = max(report.PeakInUse, report.InUse)
+	}
+	for _, a := range arenas {
+		report.Reserved += a.size
+		stats := a.index.Stats()
+		report.Free.Merge(&stats)
+	}
+	return
+}
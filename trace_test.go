@@ -0,0 +1,49 @@
+package gomem
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestTraceRecordAndReplay(t *testing.T) {
+	for _, format := range []TraceFormat{TraceBinary, TraceYAML} {
+		var out bytes.Buffer
+		recorder := NewTraceRecorder(&out, format)
+		sma := NewScalableMemoryAllocator(1<<10, WithStrategy(StrategyTwoTree), WithTraceRecorder(recorder))
+		var bufs [][]byte
+		for i := range 50 {
+			bufs = append(bufs, sma.Malloc(100+i*37))
+			if i%3 == 0 {
+				sma.Free(bufs[0])
+				bufs = bufs[1:]
+			}
+		}
+		sma.Borrow(64)
+		sma.MallocAligned(300, 256)
+		for _, buf := range bufs {
+			sma.Free(buf)
+		}
+		sma.Recycle()
+		if err := recorder.Close(); err != nil {
+			t.Fatal(err)
+		}
+		events, err := ReadTrace(&out)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if len(events) < 50 || events[0].Op != TraceInit || events[len(events)-1].Op != TraceRecycle {
+			t.Fatalf("format %d: unexpected events %v", format, events)
+		}
+		if !slices.IsSortedFunc(events, func(a, b TraceEvent) int { return int(a.Time - b.Time) }) {
+			t.Fatalf("format %d: timestamps not monotonic", format)
+		}
+		report := ReplayTrace(events, StrategyTwoTree)
+		if len(report.Divergences) != 0 {
+			t.Fatalf("format %d: divergences %v", format, report.Divergences)
+		}
+		if report.InUse != 0 || report.PeakInUse < 300 || report.Reserved != 0 {
+			t.Fatalf("format %d: in use %d, peak %d", format, report.InUse, report.PeakInUse)
+		}
+	}
+}
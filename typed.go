@@ -0,0 +1,97 @@
+package gomem
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// pointerFree caches whether a type is free of Go pointers
+var pointerFree sync.Map // reflect.Type -> bool
+
+// hasPointers reports whether values of t hold pointers the GC must see
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func,
+		reflect.Interface, reflect.Slice, reflect.String:
+		return true
+	}
+	return false
+}
+
+// checkPointerFree panics when T contains Go pointers: allocator memory is
+// not scanned by the GC (and lives outside the Go heap under enable_mmap),
+// so the objects they point to could be collected while still referenced.
+func checkPointerFree[T any]() {
+	t := reflect.TypeFor[T]()
+	free, ok := pointerFree.Load(t)
+	if !ok {
+		free, _ = pointerFree.LoadOrStore(t, !hasPointers(t))
+	}
+	if !free.(bool) {
+		panic(fmt.Sprintf("gomem: %v contains Go pointers and cannot live in allocator memory", t))
+	}
+}
+
+// New allocates a zeroed T from sma, aligned for T. It panics if T contains
+// Go pointers. Release it with Free.
+func New[T any](sma *ScalableMemoryAllocator) *T {
+	checkPointerFree[T]()
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if size == 0 {
+		return new(T)
+	}
+	mem := sma.MallocAligned(size, int(unsafe.Alignof(zero)))
+	if mem == nil {
+		return nil
+	}
+	clear(mem)
+	return (*T)(unsafe.Pointer(&mem[0]))
+}
+
+// Free releases a T allocated by New and reports whether sma owned it.
+func Free[T any](sma *ScalableMemoryAllocator, p *T) bool {
+	if p == nil || unsafe.Sizeof(*p) == 0 {
+		return false
+	}
+	return sma.Free(unsafe.Slice((*byte)(unsafe.Pointer(p)), unsafe.Sizeof(*p)))
+}
+
+// MakeSlice allocates a zeroed []T of length and capacity n from sma, aligned
+// for T. It panics if T contains Go pointers. Release it with FreeSlice.
+func MakeSlice[T any](sma *ScalableMemoryAllocator, n int) []T {
+	checkPointerFree[T]()
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if n <= 0 || size == 0 {
+		return make([]T, n)
+	}
+	mem := sma.MallocAligned(size*n, int(unsafe.Alignof(zero)))
+	if mem == nil {
+		return nil
+	}
+	clear(mem)
+	return unsafe.Slice((*T)(unsafe.Pointer(&mem[0])), n)
+}
+
+// FreeSlice releases the len(s) elements of s, which must come from
+// MakeSlice, and reports whether sma owned them. Freeing a subslice returns
+// only those elements, like Free on a part of a []byte.
+func FreeSlice[T any](sma *ScalableMemoryAllocator, s []T) bool {
+	size := int(unsafe.Sizeof(*new(T)))
+	if len(s) == 0 || size == 0 {
+		return false
+	}
+	return sma.Free(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), size*len(s)))
+}
@@ -0,0 +1,58 @@
+package gomem
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestTypedAllocation(t *testing.T) {
+	type header struct {
+		Flags uint8
+		PTS   int64
+		Sizes [4]uint32
+	}
+	sma := NewScalableMemoryAllocator(1 << 12)
+	defer sma.Recycle()
+	// make the next allocation misaligned for int64
+	pad := sma.Malloc(3)
+	h := New[header](sma)
+	if uintptr(unsafe.Pointer(h))%unsafe.Alignof(*h) != 0 {
+		t.Fatalf("header at %p is not aligned", h)
+	}
+	if *h != (header{}) {
+		t.Fatalf("header not zeroed: %+v", *h)
+	}
+	h.PTS = 90000
+	words := MakeSlice[uint32](sma, 100)
+	if len(words) != 100 || cap(words) != 100 || uintptr(unsafe.Pointer(&words[0]))%4 != 0 {
+		t.Fatalf("slice len %d cap %d at %p", len(words), cap(words), &words[0])
+	}
+	for i := range words {
+		words[i] = ^uint32(i)
+	}
+	if h.PTS != 90000 {
+		t.Fatal("slice overlaps the header")
+	}
+	if !FreeSlice(sma, words) || !Free(sma, h) || !sma.Free(pad) {
+		t.Fatal("free failed")
+	}
+	if inUse := sma.Stats().InUse; inUse != 0 {
+		t.Fatalf("in use %d after freeing everything", inUse)
+	}
+	for name, alloc := range map[string]func(){
+		"pointer":   func() { New[*int](sma) },
+		"string":    func() { MakeSlice[string](sma, 1) },
+		"struct":    func() { New[struct{ b []byte }](sma) },
+		"array":     func() { MakeSlice[[2]map[int]int](sma, 1) },
+		"interface": func() { New[any](sma) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: allocation of a pointer type did not panic", name)
+				}
+			}()
+			alloc()
+		}()
+	}
+}
@@ -0,0 +1,212 @@
+package gomem
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCorruptIndex is wrapped by the errors returned from Validate.
+var ErrCorruptIndex = errors.New("freespace: corrupt index")
+
+func corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrCorruptIndex}, args...)...)
+}
+
+// validateBlocks checks that blocks are non-empty, inside [0, size), sorted
+// by offset and that no two of them overlap or touch without being coalesced
+func validateBlocks(blocks []*Block, size int) error {
+	for i, b := range blocks {
+		if b.Start < 0 || b.End > size || b.Start >= b.End {
+			return corrupt("block [%d, %d) outside of arena of %d bytes", b.Start, b.End, size)
+		}
+		if i == 0 {
+			continue
+		}
+		if prev := blocks[i-1]; prev.End > b.Start {
+			return corrupt("blocks [%d, %d) and [%d, %d) overlap", prev.Start, prev.End, b.Start, b.End)
+		} else if prev.End == b.Start {
+			return corrupt("adjacent blocks [%d, %d) and [%d, %d) were not coalesced", prev.Start, prev.End, b.Start, b.End)
+		}
+	}
+	return nil
+}
+
+// dumpBlocks formats the free blocks of index, one per line
+func dumpBlocks(index FreeSpaceIndex) string {
+	var sb strings.Builder
+	for _, b := range index.GetBlocks() {
+		fmt.Fprintf(&sb, "  [%d, %d) %d bytes\n", b.Start, b.End, b.End-b.Start)
+	}
+	return sb.String()
+}
+
+// mustValidate panics with a dump of the free blocks when index is corrupt.
+// The indexes defer it from every mutating call under the gomem_debug tag.
+func mustValidate(index FreeSpaceIndex, op string) {
+	if err := index.Validate(); err != nil {
+		panic(fmt.Sprintf("gomem: %v index after %s: %v\nfree blocks:\n%s", index.Strategy(), op, err, dumpBlocks(index)))
+	}
+}
+
+// Validate checks the BST order by Start, the min-heap order by size, the
+// parent links and maxSize of the treap, and that its free blocks are
+// coalesced. It returns an error wrapping ErrCorruptIndex on the first
+// violation found.
+func (a *Allocator) Validate() error {
+	if a.sizeTree != nil && a.sizeTree.parent != nil {
+		return corrupt("treap root [%d, %d) has a parent", a.sizeTree.Start, a.sizeTree.End)
+	}
+	visited := make(map[*treapBlock]bool)
+	var check func(b *treapBlock, lo, hi int) error
+	check = func(b *treapBlock, lo, hi int) error {
+		if b == nil {
+			return nil
+		}
+		if visited[b] {
+			return corrupt("treap block [%d, %d) is reachable twice", b.Start, b.End)
+		}
+		visited[b] = true
+		if b.Start < lo || b.Start >= hi {
+			return corrupt("treap block [%d, %d) breaks the offset order within [%d, %d)", b.Start, b.End, lo, hi)
+		}
+		for _, child := range [...]*treapBlock{b.left, b.right} {
+			if child == nil {
+				continue
+			}
+			if child.parent != b {
+				return corrupt("treap block [%d, %d) has a wrong parent link", child.Start, child.End)
+			}
+			if child.End-child.Start < b.End-b.Start {
+				return corrupt("treap block [%d, %d) is smaller than its parent [%d, %d)", child.Start, child.End, b.Start, b.End)
+			}
+		}
+		if err := check(b.left, lo, b.Start); err != nil {
+			return err
+		}
+		if err := check(b.right, b.Start+1, hi); err != nil {
+			return err
+		}
+		if want := max(b.End-b.Start, b.left.getMaxSize(), b.right.getMaxSize()); b.maxSize != want {
+			return corrupt("treap block [%d, %d) has maxSize %d, want %d", b.Start, b.End, b.maxSize, want)
+		}
+		return nil
+	}
+	if err := check(a.sizeTree, 0, a.Size+1); err != nil {
+		return err
+	}
+	return validateBlocks(a.GetBlocks(), a.Size)
+}
+
+// Validate checks the order, heights, balance and maxSize of both AVL trees,
+// that they hold the same blocks, and that the free blocks are coalesced.
+// It returns an error wrapping ErrCorruptIndex on the first violation found.
+func (a *TwoTreeAllocator) Validate() error {
+	var nodes [2]map[*twoTreeBlock]bool
+	for treeIndex, root := range [2]*twoTreeBlock{a.sizeTree, a.offsetTree} {
+		visited := make(map[*twoTreeBlock]bool)
+		var check func(b, lo, hi *twoTreeBlock) error
+		check = func(b, lo, hi *twoTreeBlock) error {
+			if b == nil {
+				return nil
+			}
+			if visited[b] {
+				return corrupt("tree %d: block [%d, %d) is reachable twice", treeIndex, b.Start, b.End)
+			}
+			visited[b] = true
+			less := compares[treeIndex]
+			if lo != nil && !less(lo, b) || hi != nil && !less(b, hi) {
+				return corrupt("tree %d: block [%d, %d) is out of order", treeIndex, b.Start, b.End)
+			}
+			tree := &b.trees[treeIndex]
+			if err := check(tree.left, lo, b); err != nil {
+				return err
+			}
+			if err := check(tree.right, b, hi); err != nil {
+				return err
+			}
+			lh, rh := b.getLeftHeight(treeIndex), b.getRightHeight(treeIndex)
+			if tree.height != 1+max(lh, rh) {
+				return corrupt("tree %d: block [%d, %d) has height %d, want %d", treeIndex, b.Start, b.End, tree.height, 1+max(lh, rh))
+			}
+			if lh-rh > 1 || rh-lh > 1 {
+				return corrupt("tree %d: block [%d, %d) is unbalanced (%d, %d)", treeIndex, b.Start, b.End, lh, rh)
+			}
+			if want := max(b.End-b.Start, tree.left.getMaxSize(treeIndex), tree.right.getMaxSize(treeIndex)); tree.maxSize != want {
+				return corrupt("tree %d: block [%d, %d) has maxSize %d, want %d", treeIndex, b.Start, b.End, tree.maxSize, want)
+			}
+			return nil
+		}
+		if err := check(root, nil, nil); err != nil {
+			return err
+		}
+		nodes[treeIndex] = visited
+	}
+	if len(nodes[TreeIndexSize]) != len(nodes[TreeIndexOffset]) {
+		return corrupt("sizeTree has %d blocks, offsetTree has %d", len(nodes[TreeIndexSize]), len(nodes[TreeIndexOffset]))
+	}
+	for b := range nodes[TreeIndexSize] {
+		if !nodes[TreeIndexOffset][b] {
+			return corrupt("block [%d, %d) is in sizeTree but not in offsetTree", b.Start, b.End)
+		}
+	}
+	return validateBlocks(a.GetBlocks(), a.Size)
+}
+
+// Validate checks that the size class lists, their bitmaps, the address
+// links, the end index and the free size describe the same coalesced free
+// blocks. It returns an error wrapping ErrCorruptIndex on the first violation
+// found.
+func (a *TLSFAllocator) Validate() error {
+	linked, freeSize := 0, 0
+	var prev *tlsfBlock
+	for b := a.first; b != nil; prev, b = b, b.nextPhys {
+		if linked++; linked > a.Size {
+			return corrupt("address links form a cycle")
+		}
+		if b.prevPhys != prev {
+			return corrupt("block [%d, %d) has a wrong prevPhys link", b.Start, b.End)
+		}
+		if a.endAtLeast(b.End) != b {
+			return corrupt("block [%d, %d) is missing from the end index", b.Start, b.End)
+		}
+		freeSize += b.End - b.Start
+	}
+	if a.last != prev {
+		return corrupt("last block is not the end of the address links")
+	}
+	if freeSize != a.freeSize {
+		return corrupt("free size is %d, blocks hold %d bytes", a.freeSize, freeSize)
+	}
+	if indexed := a.ends.count(a.endShift); indexed != linked {
+		return corrupt("%d blocks in the end index, %d linked", indexed, linked)
+	}
+	listed := 0
+	for fl := range tlsfFLCount {
+		for sl := range tlsfSLCount {
+			head := a.heads[fl][sl]
+			if bit := a.slBitmap[fl]&(1<<sl) != 0; bit != (head != nil) {
+				return corrupt("class %d/%d: bitmap is %v but list is empty: %v", fl, sl, bit, head == nil)
+			}
+			var prev *tlsfBlock
+			for b := head; b != nil; prev, b = b, b.nextFree {
+				if listed++; listed > linked {
+					return corrupt("class lists hold more blocks than the %d linked", linked)
+				}
+				if b.prevFree != prev {
+					return corrupt("block [%d, %d) has a wrong prevFree link", b.Start, b.End)
+				}
+				if f, s := tlsfMapping(b.End - b.Start); f != fl || s != sl {
+					return corrupt("block [%d, %d) is in class %d/%d, want %d/%d", b.Start, b.End, fl, sl, f, s)
+				}
+			}
+		}
+		if bit := a.flBitmap&(1<<fl) != 0; bit != (a.slBitmap[fl] != 0) {
+			return corrupt("first level bitmap of class %d disagrees with its second level", fl)
+		}
+	}
+	if listed != linked {
+		return corrupt("%d listed blocks, %d linked", listed, linked)
+	}
+	return validateBlocks(a.GetBlocks(), a.Size)
+}